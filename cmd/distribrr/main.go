@@ -41,6 +41,7 @@ func main() {
 
 	rootCmd.AddCommand(cmd.CommandServer())
 	rootCmd.AddCommand(cmd.CommandAgent())
+	rootCmd.AddCommand(cmd.CommandScheduler())
 
 	rootCmd.AddCommand(CmdVersion())
 	rootCmd.AddCommand(CmdUpdate())
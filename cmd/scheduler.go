@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/scheduler"
+	"github.com/autobrr/distribrr/pkg/simulator"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+func CommandScheduler() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "scheduler",
+		Short:        "scheduler subcommands",
+		Example:      `  distribrr scheduler simulate --nodes nodes.jsonl --tasks tasks.jsonl`,
+		SilenceUsage: false,
+	}
+
+	command.AddCommand(CommandSchedulerSimulate())
+
+	return command
+}
+
+func CommandSchedulerSimulate() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "simulate",
+		Short: "Replay a task trace through schedulers against recorded node stats",
+		Long: `Replay a task trace through schedulers against recorded node stats, without touching any agent.
+
+--nodes is JSON lines of node snapshots, each applying until the next snapshot of the same node:
+  {"time": "2024-01-01T12:00:00Z", "node": "node-1", "labels": {"region": "eu"}, "stats": {...}}
+
+--tasks is JSON lines of tasks, with an optional download duration:
+  {"time": "2024-01-01T12:01:00Z", "duration": "45m", "task": {"name": "...", "indexer": "...", "size": 1073741824}}
+
+Downloads placed by the simulation are added to the recorded stats until their duration runs out,
+so each scheduler sees the load it caused itself.`,
+		Example: `  distribrr scheduler simulate --nodes nodes.jsonl --tasks tasks.jsonl
+  distribrr scheduler simulate --nodes nodes.jsonl --tasks tasks.jsonl --scheduler leastactive,epvm --interval 15m
+  distribrr scheduler simulate --nodes nodes.jsonl --tasks tasks.jsonl --scheduler all --output json`,
+		SilenceUsage: true,
+	}
+
+	var (
+		nodesPath  string
+		tasksPath  string
+		schedulers []string
+		output     string
+		verbose    bool
+	)
+
+	cfg := simulator.Config{}
+
+	command.Flags().StringVar(&nodesPath, "nodes", "", "Path to node snapshots, JSON lines")
+	command.Flags().StringVar(&tasksPath, "tasks", "", "Path to task trace, JSON lines")
+	command.Flags().StringSliceVar(&schedulers, "scheduler", []string{scheduler.DefaultScheduler}, fmt.Sprintf("Schedulers to compare, or all: %s", strings.Join(scheduler.Names(), ", ")))
+	command.Flags().DurationVar(&cfg.DownloadTime, "download-time", 30*time.Minute, "How long a download takes when the trace has no duration for it")
+	command.Flags().DurationVar(&cfg.Interval, "interval", 10*time.Minute, "Interval between load samples. 0 disables them")
+	command.Flags().StringVar(&output, "output", "text", "Print as [text, json]")
+	command.Flags().BoolVar(&verbose, "verbose", false, "Log scheduler decisions")
+
+	_ = command.MarkFlagRequired("nodes")
+	_ = command.MarkFlagRequired("tasks")
+
+	command.RunE = func(cmd *cobra.Command, args []string) error {
+		if !verbose {
+			zerolog.SetGlobalLevel(zerolog.WarnLevel)
+		}
+
+		if len(schedulers) == 1 && schedulers[0] == "all" {
+			schedulers = scheduler.Names()
+		}
+
+		for _, name := range schedulers {
+			if !scheduler.Exists(name) {
+				return errors.Errorf("unknown scheduler %q, available: %s", name, strings.Join(scheduler.Names(), ", "))
+			}
+		}
+
+		snapshots, err := readFile(nodesPath, simulator.ReadSnapshots)
+		if err != nil {
+			return err
+		}
+
+		trace, err := readFile(tasksPath, simulator.ReadTrace)
+		if err != nil {
+			return err
+		}
+
+		reports := make([]*simulator.Report, 0, len(schedulers))
+
+		for _, name := range schedulers {
+			report, err := simulator.Run(context.Background(), name, cfg, snapshots, trace)
+			if err != nil {
+				return errors.Wrapf(err, "could not simulate %s", name)
+			}
+
+			reports = append(reports, report)
+		}
+
+		switch output {
+		case "json":
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(reports)
+		case "text":
+			printReports(cmd.OutOrStdout(), reports)
+			return nil
+		}
+
+		return errors.Errorf("unknown output %q", output)
+	}
+
+	return command
+}
+
+func readFile[T any](path string, read func(io.Reader) ([]T, error)) ([]T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open %s", path)
+	}
+	defer f.Close()
+
+	items, err := read(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", path)
+	}
+
+	return items, nil
+}
+
+func printReports(out io.Writer, reports []*simulator.Report) {
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+
+		fmt.Fprintf(out, "scheduler %s: %d tasks, %d placed, %d rejected, %d replicas, %d under-replicated\n",
+			report.Scheduler, report.Tasks, report.Placed, len(report.Rejected), report.Replicas, report.UnderReplicated)
+
+		nodes := slices.Sorted(maps.Keys(report.Placements))
+		for _, sample := range report.Load {
+			for name := range sample.Nodes {
+				if !slices.Contains(nodes, name) {
+					nodes = append(nodes, name)
+				}
+			}
+		}
+		slices.Sort(nodes)
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(w, "\nnode\treplicas\tshare")
+		for _, name := range nodes {
+			share := 0.0
+			if report.Replicas > 0 {
+				share = float64(report.Placements[name]) / float64(report.Replicas) * 100
+			}
+			fmt.Fprintf(w, "%s\t%d\t%.1f%%\n", name, report.Placements[name], share)
+		}
+		_ = w.Flush()
+
+		if len(report.Rejected) > 0 {
+			fmt.Fprintln(out, "\nrejected:")
+			for _, r := range report.Rejected {
+				fmt.Fprintf(out, "  %s %s: %s\n", r.Time.Format(time.RFC3339), r.Task, r.Reason)
+			}
+		}
+
+		if len(report.Load) > 0 {
+			fmt.Fprintln(out, "\nactive downloads (simulated in parentheses):")
+
+			w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "time\t%s\n", strings.Join(nodes, "\t"))
+
+			for _, sample := range report.Load {
+				row := make([]string, 0, len(nodes))
+				for _, name := range nodes {
+					load, ok := sample.Nodes[name]
+					if !ok {
+						row = append(row, "-")
+						continue
+					}
+					row = append(row, fmt.Sprintf("%d (%d)", load.ActiveDownloads, load.Simulated))
+				}
+				fmt.Fprintf(w, "%s\t%s\n", sample.Time.Format(time.RFC3339), strings.Join(row, "\t"))
+			}
+			_ = w.Flush()
+		}
+	}
+}
@@ -36,6 +36,7 @@ func CommandServerRun() *cobra.Command {
 	command.Flags().StringVar(&cfg.Http.Host, "http-host", "", "HTTP Host. Default: localhost")
 	command.Flags().StringVar(&cfg.Http.Port, "http-port", "7422", "HTTP port. Default: 7422")
 	command.Flags().StringVar(&cfg.Http.Token, "http-api-token", "", "API token")
+	command.Flags().StringVar(&cfg.Database.Path, "database-path", "", "Path to database file. Default: distribrr.db next to the config file")
 
 	command.Run = func(cmd *cobra.Command, args []string) {
 		if err := cfg.LoadFromFile(configPath); err != nil {
@@ -46,7 +47,12 @@ func CommandServerRun() *cobra.Command {
 			log.Fatal().Msg("http.token must be set; refusing to start without an API token")
 		}
 
-		app := server.NewService(cfg)
+		db := server.NewDB(cfg.DatabasePath())
+		if err := db.Open(); err != nil {
+			log.Fatal().Err(err).Msgf("could not open database: %s", cfg.DatabasePath())
+		}
+
+		app := server.NewService(cfg, db)
 		app.Run()
 	}
 
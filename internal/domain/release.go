@@ -1,7 +1,9 @@
 package domain
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"time"
@@ -21,6 +23,9 @@ import (
 
 var ErrUnrecoverableError = errors.New("unrecoverable error")
 
+// maxTorrentFileSize guards against indexers returning something other than a torrent file
+const maxTorrentFileSize = 50 * 1024 * 1024
+
 func NewRelease(url string, name string, indexer string) *Release {
 	return &Release{
 		Url:     url,
@@ -32,12 +37,13 @@ func NewRelease(url string, name string, indexer string) *Release {
 }
 
 type Release struct {
-	Url       string
-	RawCookie string
-	Hash      string
-	Name      string
-	Indexer   string
-	Size      uint64
+	Url          string
+	RawCookie    string
+	Hash         string
+	Name         string
+	Indexer      string
+	Size         uint64
+	TorrentBytes []byte
 }
 
 func (r *Release) SetCookies(cookie string) {
@@ -89,24 +95,15 @@ func (r *Release) DownloadTorrentFile(ctx context.Context) error {
 			return errors.Errorf("unexpected status: %v", resp.StatusCode)
 		}
 
-		meta, err := metainfo.Load(resp.Body)
-		if err != nil {
-			return retry.Unrecoverable(errors.Wrapf(err, "metainfo could not load file contents: %v", r.Name))
-		}
-
-		torrentMetaInfo, err := meta.UnmarshalInfo()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize))
 		if err != nil {
-			return retry.Unrecoverable(errors.Wrapf(err, "metainfo could not unmarshal info from torrent: %v", r.Name))
+			return errors.Wrapf(err, "could not read torrent file: %v", r.Name)
 		}
 
-		hashInfoBytes := meta.HashInfoBytes().Bytes()
-		if len(hashInfoBytes) < 1 {
-			return retry.Unrecoverable(errors.New("could not read infohash"))
+		if err := r.LoadTorrentBytes(body); err != nil {
+			return retry.Unrecoverable(err)
 		}
 
-		r.Hash = meta.HashInfoBytes().String()
-		r.Size = uint64(torrentMetaInfo.TotalLength())
-
 		return nil
 	},
 		retry.Delay(time.Second*3),
@@ -116,3 +113,27 @@ func (r *Release) DownloadTorrentFile(ctx context.Context) error {
 
 	return errFunc
 }
+
+// LoadTorrentBytes validates the metainfo in b and keeps it, along with its infohash and size.
+func (r *Release) LoadTorrentBytes(b []byte) error {
+	meta, err := metainfo.Load(bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "metainfo could not load file contents: %v", r.Name)
+	}
+
+	torrentMetaInfo, err := meta.UnmarshalInfo()
+	if err != nil {
+		return errors.Wrapf(err, "metainfo could not unmarshal info from torrent: %v", r.Name)
+	}
+
+	hashInfoBytes := meta.HashInfoBytes().Bytes()
+	if len(hashInfoBytes) < 1 {
+		return errors.New("could not read infohash")
+	}
+
+	r.Hash = meta.HashInfoBytes().String()
+	r.Size = uint64(torrentMetaInfo.TotalLength())
+	r.TorrentBytes = b
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -170,6 +171,7 @@ func (s *Service) Join(ctx context.Context, addr string, token string, agent Age
 		NodeName:   nodeName,
 		ClientAddr: agent.ClientAddr,
 		Labels:     agent.Labels,
+		Taints:     agent.Taints,
 		Token:      agentToken,
 	}
 
@@ -233,14 +235,14 @@ func (s *Service) RunTasks() {
 }
 
 func (s *Service) runTask(t task.Task) error {
-	if err := s.StartTask(t); err != nil {
+	if _, err := s.StartTask(t); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *Service) StartTask(t task.Task) error {
+func (s *Service) StartTask(t task.Task) (*StartTaskResponse, error) {
 	sender := errgroup.Group{}
 	//downloads := 0
 
@@ -257,18 +259,24 @@ func (s *Service) StartTask(t task.Task) error {
 	}
 
 	rel := domain.NewRelease(t.DownloadURL, t.Name, t.Indexer)
-	if err := rel.DownloadTorrentFile(ctx); err != nil {
-		return err
+
+	// the server sends the torrent along, only download it when talking to an older server
+	if len(t.Torrent) > 0 {
+		if err := rel.LoadTorrentBytes(t.Torrent); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := rel.DownloadTorrentFile(ctx); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, client := range s.clients {
 		sender.Go(func() error {
 			log.Debug().Msgf("add torrent %s to client %s", t.Name, client.Name)
 
-			// TODO read from memory
-
 			// send downloads
-			if _, err := client.Client.AddTorrentFromUrlCtx(ctx, t.DownloadURL, opts); err != nil {
+			if _, err := client.Client.AddTorrentFromMemoryCtx(ctx, rel.TorrentBytes, opts); err != nil {
 				log.Error().Err(err).Msgf("error adding torrent from file %s to qbit: %s", t.Name, client.Name)
 				return err
 			}
@@ -304,18 +312,82 @@ func (s *Service) StartTask(t task.Task) error {
 
 	if err := sender.Wait(); err != nil {
 		log.Error().Err(err).Msg("error adding torrent to client")
+		return nil, err
+	}
+
+	return &StartTaskResponse{TaskID: t.ID, Hash: rel.Hash}, nil
+}
+
+// StopTask removes the torrent from every client, optionally deleting its files.
+func (s *Service) StopTask(ctx context.Context, hash string, deleteFiles bool) error {
+	remover := errgroup.Group{}
+
+	for _, client := range s.clients {
+		remover.Go(func() error {
+			log.Debug().Msgf("remove torrent %s from client %s, delete files: %t", hash, client.Name, deleteFiles)
+
+			if err := client.Client.DeleteTorrentsCtx(ctx, []string{hash}, deleteFiles); err != nil {
+				log.Error().Err(err).Msgf("could not remove torrent %s from client: %s", hash, client.Name)
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	if err := remover.Wait(); err != nil {
 		return err
 	}
 
+	log.Info().Msgf("removed torrent %s", hash)
+
 	return nil
 }
 
-func (s *Service) StopTask(t task.Task) {
+// InspectTask returns the live state of the torrent in every client that has it.
+func (s *Service) InspectTask(ctx context.Context, hash string) ([]TorrentStatus, error) {
+	var (
+		m        sync.Mutex
+		statuses = make([]TorrentStatus, 0)
+	)
 
-}
+	fetcher := errgroup.Group{}
 
-func (s *Service) InspectTask(t task.Task) {
+	for _, client := range s.clients {
+		fetcher.Go(func() error {
+			torrents, err := client.Client.GetTorrentsCtx(ctx, qbittorrent.TorrentFilterOptions{Hashes: []string{hash}})
+			if err != nil {
+				log.Error().Err(err).Msgf("could not get torrent %s from client: %s", hash, client.Name)
+				return err
+			}
+
+			m.Lock()
+			defer m.Unlock()
+
+			for _, torrent := range torrents {
+				statuses = append(statuses, TorrentStatus{
+					Client:   client.Name,
+					Hash:     torrent.Hash,
+					Name:     torrent.Name,
+					State:    string(torrent.State),
+					Progress: torrent.Progress,
+					ETA:      torrent.ETA,
+					DlSpeed:  torrent.DlSpeed,
+					UpSpeed:  torrent.UpSpeed,
+					Ratio:    torrent.Ratio,
+					Size:     torrent.Size,
+				})
+			}
+
+			return nil
+		})
+	}
+
+	if err := fetcher.Wait(); err != nil {
+		return nil, err
+	}
 
+	return statuses, nil
 }
 
 func (s *Service) UpdateTasks(t task.Task) {
@@ -365,22 +437,57 @@ func (s *Service) GetClientStats(ctx context.Context) *stats.Stats {
 
 		l.Trace().Msg("check disk per path for client")
 
+		storageStats := make([]stats.StorageStats, 0, len(client.Rules.Storage))
+
 		for _, storage := range client.Rules.Storage {
 			l.Trace().Msgf("check disk for path %q", storage.Path)
 
-			s.stats.DiskPathStats[storage.Path] = stats.GetDiskInfoByPath(storage.Path)
+			disk := stats.GetDiskInfoByPath(storage.Path)
+			s.stats.DiskPathStats[storage.Path] = disk
+
+			minFree, maxUsage, err := storage.Limits()
+			if err != nil {
+				l.Error().Err(err).Msg("could not parse storage rule")
+				continue
+			}
+
+			storageStats = append(storageStats, stats.StorageStats{
+				Path:     storage.Path,
+				Tier:     storage.Tier,
+				MinFree:  minFree,
+				MaxUsage: maxUsage,
+				Total:    disk.All,
+				Used:     disk.Used,
+				Free:     disk.Free,
+			})
 		}
 
 		l.Trace().Msg("get active torrents for client")
 
 		status := stats.ClientStatusNotReady
 
-		activeDownloads, err := client.Client.GetTorrentsActiveDownloadsCtx(ctx)
+		// the downloading filter leaves out seeding torrents on the client side, but keeps queued, checking and paused ones
+		torrents, err := client.Client.GetTorrentsCtx(ctx, qbittorrent.TorrentFilterOptions{Filter: qbittorrent.TorrentFilterDownloading})
 		if err != nil {
 			l.Error().Err(err).Msgf("could not load active torrents for client")
 			continue
 		}
 
+		// queued, checking and paused torrents aren't active downloads, but still aren't done downloading
+		activeDownloads := make([]qbittorrent.Torrent, 0)
+		incomplete := make([]string, 0)
+		for _, torrent := range torrents {
+			if torrent.Progress >= 1 {
+				continue
+			}
+
+			incomplete = append(incomplete, torrent.Hash)
+
+			if torrent.State == qbittorrent.TorrentStateDownloading || torrent.State == qbittorrent.TorrentStateStalledDl {
+				activeDownloads = append(activeDownloads, torrent)
+			}
+		}
+
 		if len(activeDownloads) < client.Rules.Torrents.MaxActiveDownloads {
 			status = stats.ClientStatusReady
 		} else if len(activeDownloads) > client.Rules.Torrents.MaxActiveDownloads {
@@ -408,8 +515,10 @@ func (s *Service) GetClientStats(ctx context.Context) *stats.Stats {
 			MaxActiveDownloadsAllowed: client.Rules.Torrents.MaxActiveDownloads,
 			ActiveDownloadsCount:      len(activeDownloads),
 			ActiveDownloads:           activeDownloads,
+			Incomplete:                incomplete,
 			Ready:                     len(activeDownloads) < client.Rules.Torrents.MaxActiveDownloads,
 			Status:                    status,
+			Storage:                   storageStats,
 		}
 
 		l.Trace().Msgf("[%d/%d] active downloads, status: %s", len(activeDownloads), client.Rules.Torrents.MaxActiveDownloads, ct.Status)
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"strconv"
 
 	mw "github.com/autobrr/distribrr/pkg/middleware"
 	"github.com/autobrr/distribrr/pkg/task"
@@ -98,20 +99,52 @@ func (s *APIServer) Handler() http.Handler {
 						return
 					}
 
-					if err := s.service.StartTask(te.Task); err != nil {
+					resp, err := s.service.StartTask(te.Task)
+					if err != nil {
 						render.Status(r, http.StatusInternalServerError)
 						render.JSON(w, r, map[string]string{"error": err.Error()})
 						return
 					}
 
 					render.Status(r, http.StatusCreated)
-					render.PlainText(w, r, "OK")
+					render.JSON(w, r, resp)
 				})
 
 				r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 					render.Status(r, http.StatusOK)
 					render.PlainText(w, r, "OK")
 				})
+
+				r.Get("/{hash}", func(w http.ResponseWriter, r *http.Request) {
+					statuses, err := s.service.InspectTask(r.Context(), chi.URLParam(r, "hash"))
+					if err != nil {
+						render.Status(r, http.StatusInternalServerError)
+						render.JSON(w, r, map[string]string{"error": err.Error()})
+						return
+					}
+
+					if len(statuses) == 0 {
+						render.Status(r, http.StatusNotFound)
+						render.JSON(w, r, map[string]string{"error": "torrent not found"})
+						return
+					}
+
+					render.Status(r, http.StatusOK)
+					render.JSON(w, r, statuses)
+				})
+
+				r.Delete("/{hash}", func(w http.ResponseWriter, r *http.Request) {
+					deleteFiles, _ := strconv.ParseBool(r.URL.Query().Get("delete_files"))
+
+					if err := s.service.StopTask(r.Context(), chi.URLParam(r, "hash"), deleteFiles); err != nil {
+						render.Status(r, http.StatusInternalServerError)
+						render.JSON(w, r, map[string]string{"error": err.Error()})
+						return
+					}
+
+					render.Status(r, http.StatusOK)
+					render.PlainText(w, r, "OK")
+				})
 			})
 
 			r.Route("/stats", func(r chi.Router) {
@@ -14,6 +14,7 @@ import (
 	"github.com/autobrr/distribrr/pkg/task"
 	"github.com/autobrr/distribrr/pkg/version"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/xid"
 )
@@ -169,22 +170,101 @@ func (c *Client) verifyToken(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) StartTask(ctx context.Context, te *task.Event) error {
+func (c *Client) StartTask(ctx context.Context, te *task.Event) (*StartTaskResponse, error) {
 	return c.startTask(ctx, te)
 }
 
-func (c *Client) startTask(ctx context.Context, te *task.Event) error {
+func (c *Client) startTask(ctx context.Context, te *task.Event) (*StartTaskResponse, error) {
 	reqUrl, err := c.buildUrl(c.addr, "tasks", nil)
 	if err != nil {
-		return errors.Wrapf(err, "could not build URL: %s", c.name)
+		return nil, errors.Wrapf(err, "could not build URL: %s", c.name)
 	}
 
 	body, err := json.Marshal(te)
 	if err != nil {
-		return errors.Wrapf(err, "could not marshal request for node: %s", c.name)
+		return nil, errors.Wrapf(err, "could not marshal request for node: %s", c.name)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqUrl.String(), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create request for node: %s", c.name)
+	}
+
+	c.setHeaders(ctx, req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error during request for node: %s", c.name)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("node: %s unexpected status: %d: %s", c.name, resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+
+	// older agents reply with plain text, so a missing body is not an error
+	data := StartTaskResponse{TaskID: te.Task.ID}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return &StartTaskResponse{TaskID: te.Task.ID}, nil
+	}
+
+	return &data, nil
+}
+
+func (c *Client) InspectTask(ctx context.Context, hash string) ([]TorrentStatus, error) {
+	return c.inspectTask(ctx, hash)
+}
+
+func (c *Client) inspectTask(ctx context.Context, hash string) ([]TorrentStatus, error) {
+	reqUrl, err := c.buildUrl(c.addr, "tasks/"+hash, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not build URL: %s", c.name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl.String(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create request for node: %s", c.name)
+	}
+
+	c.setHeaders(ctx, req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error during request for node: %s", c.name)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node: %s unexpected status: %d", c.name, resp.StatusCode)
+	}
+
+	var data []TorrentStatus
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (c *Client) StopTask(ctx context.Context, hash string, deleteFiles bool) error {
+	return c.stopTask(ctx, hash, deleteFiles)
+}
+
+func (c *Client) stopTask(ctx context.Context, hash string, deleteFiles bool) error {
+	params := map[string]string{}
+	if deleteFiles {
+		params["delete_files"] = "true"
+	}
+
+	reqUrl, err := c.buildUrl(c.addr, "tasks/"+hash, params)
+	if err != nil {
+		return errors.Wrapf(err, "could not build URL: %s", c.name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, reqUrl.String(), nil)
 	if err != nil {
 		return errors.Wrapf(err, "could not create request for node: %s", c.name)
 	}
@@ -198,7 +278,7 @@ func (c *Client) startTask(ctx context.Context, te *task.Event) error {
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
 		return fmt.Errorf("node: %s unexpected status: %d: %s", c.name, resp.StatusCode, bytes.TrimSpace(respBody))
 	}
@@ -230,6 +310,25 @@ type DownloadRequest struct {
 	Opts        map[string]string `json:"opts"`
 }
 
+type StartTaskResponse struct {
+	TaskID uuid.UUID `json:"task_id"`
+	Hash   string    `json:"hash"`
+}
+
+// TorrentStatus is the live state of a torrent in one of the agent clients.
+type TorrentStatus struct {
+	Client   string  `json:"client"`
+	Hash     string  `json:"hash"`
+	Name     string  `json:"name"`
+	State    string  `json:"state"`
+	Progress float64 `json:"progress"`
+	ETA      int64   `json:"eta"`
+	DlSpeed  int64   `json:"dlspeed"`
+	UpSpeed  int64   `json:"upspeed"`
+	Ratio    float64 `json:"ratio"`
+	Size     int64   `json:"size"`
+}
+
 func (c *Client) buildUrl(addr string, endpoint string, params map[string]string) (*url.URL, error) {
 	apiBase := "/api/v1/"
 
@@ -3,7 +3,10 @@ package agent
 import (
 	"os"
 
+	"github.com/autobrr/distribrr/pkg/task"
+
 	"github.com/autobrr/go-qbittorrent"
+	"github.com/dustin/go-humanize"
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
@@ -24,6 +27,7 @@ type Agent struct {
 	NodeName   string            `yaml:"nodeName"`
 	ClientAddr string            `yaml:"clientAddr"`
 	Labels     map[string]string `yaml:"labels"`
+	Taints     []task.Taint      `yaml:"taints"`
 }
 
 type Manager struct {
@@ -104,6 +108,23 @@ type StorageRule struct {
 	MaxUsage string `yaml:"maxUsage"`
 }
 
+// Limits parses MinFree and MaxUsage, like 50GB. Empty values are 0, meaning no limit.
+func (r StorageRule) Limits() (minFree uint64, maxUsage uint64, err error) {
+	if r.MinFree != "" {
+		if minFree, err = humanize.ParseBytes(r.MinFree); err != nil {
+			return 0, 0, errors.Wrapf(err, "invalid minFree for path %q", r.Path)
+		}
+	}
+
+	if r.MaxUsage != "" {
+		if maxUsage, err = humanize.ParseBytes(r.MaxUsage); err != nil {
+			return 0, 0, errors.Wrapf(err, "invalid maxUsage for path %q", r.Path)
+		}
+	}
+
+	return minFree, maxUsage, nil
+}
+
 type TorrentRules struct {
 	MaxActiveDownloads int `yaml:"maxActiveDownloads"`
 }
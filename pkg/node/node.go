@@ -3,6 +3,9 @@ package node
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/autobrr/distribrr/pkg/agent"
@@ -12,6 +15,9 @@ import (
 
 type Status string
 
+// holdTimeout is how long resources stay reserved for an accepted torrent the agent doesn't report as downloading
+const holdTimeout = 5 * time.Minute
+
 const (
 	StatusReady    = "READY"
 	StatusNotReady = "NOT_READY"
@@ -25,6 +31,8 @@ type Node struct {
 	Ip              string            `json:"ip"`
 	Api             string            `json:"-"`
 	Token           string            `json:"-"`
+	Cpu             int               `json:"-"`
+	CpuAllocated    float64           `json:"-"`
 	Memory          int64             `json:"-"`
 	MemoryAllocated int64             `json:"-"`
 	Disk            int64             `json:"-"`
@@ -35,8 +43,27 @@ type Node struct {
 	DateCreated     time.Time         `json:"date_created"`
 	Status          Status            `json:"status"`
 	Labels          map[string]string `json:"labels"`
+	Taints          []task.Taint      `json:"taints,omitempty"`        // reported by the agent
+	ServerTaints    []task.Taint      `json:"server_taints,omitempty"` // set in the server config
 
 	client *agent.Client
+
+	// held are allocations of accepted torrents, by infohash, kept until the agent reports them
+	held map[string]allocation
+
+	// allocMu guards the allocated fields and held, which are changed by concurrent dispatches
+	allocMu sync.Mutex
+
+	// statsErr is why the last stats fetch failed, statsUpdated when stats were last set
+	statsErr     error
+	statsUpdated time.Time
+}
+
+type allocation struct {
+	cpu    float64
+	memory int64
+	disk   int64
+	until  time.Time
 }
 
 func NewNode(name string, clientAddr string, token string, role string) *Node {
@@ -51,13 +78,34 @@ func NewNode(name string, clientAddr string, token string, role string) *Node {
 	}
 }
 
-func (n *Node) StartTask(ctx context.Context, te *task.Event) error {
-	err := n.client.StartTask(ctx, te)
+// NewOfflineNode creates a ready node without an agent, which reports the given stats, like a recorded snapshot.
+func NewOfflineNode(name string, labels map[string]string, nodeStats stats.Stats) *Node {
+	return &Node{
+		Name:        name,
+		Role:        "worker",
+		Status:      StatusReady,
+		Labels:      labels,
+		Cpu:         nodeStats.CpuCount,
+		Stats:       nodeStats,
+		DateCreated: time.Now().UTC(),
+	}
+}
+
+func (n *Node) StartTask(ctx context.Context, te *task.Event) (*agent.StartTaskResponse, error) {
+	resp, err := n.client.StartTask(ctx, te)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return resp, nil
+}
+
+func (n *Node) InspectTask(ctx context.Context, hash string) ([]agent.TorrentStatus, error) {
+	return n.client.InspectTask(ctx, hash)
+}
+
+func (n *Node) StopTask(ctx context.Context, hash string, deleteFiles bool) error {
+	return n.client.StopTask(ctx, hash, deleteFiles)
 }
 
 func (n *Node) HealthCheck(ctx context.Context) error {
@@ -68,7 +116,25 @@ func (n *Node) VerifyToken(ctx context.Context) error {
 	return n.client.VerifyToken(ctx)
 }
 
+// GetStats fetches the stats from the agent and sets them on the node.
 func (n *Node) GetStats(ctx context.Context) (*stats.Stats, error) {
+	nodeStats, err := n.FetchStats(ctx)
+	n.SetStats(nodeStats, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &n.Stats, nil
+}
+
+// FetchStats fetches the stats from the agent without setting them on the node, so callers can fetch them
+// without holding the lock that guards reading the node stats, and set them with SetStats under it.
+func (n *Node) FetchStats(ctx context.Context) (*stats.Stats, error) {
+	// offline nodes, like the ones in the scheduler simulator, report their recorded stats
+	if n.client == nil {
+		return &n.Stats, nil
+	}
+
 	nodeStats, err := n.client.GetStats(ctx)
 	if err != nil {
 		return nil, err
@@ -78,15 +144,121 @@ func (n *Node) GetStats(ctx context.Context) (*stats.Stats, error) {
 		return nil, fmt.Errorf("error getting stats from node %s", n.Name)
 	}
 
+	return nodeStats, nil
+}
+
+// SetStats sets the stats, or the error, of a FetchStats call on the node, and releases the holds
+// of torrents the stats report.
+func (n *Node) SetStats(nodeStats *stats.Stats, err error) {
+	if n.client == nil {
+		return
+	}
+
+	n.statsErr = err
+	if err != nil {
+		return
+	}
+
+	n.Cpu = nodeStats.CpuCount
 	n.Memory = int64(nodeStats.MemTotalKb())
 	n.Disk = int64(nodeStats.DiskTotal())
 	n.Stats = *nodeStats
+	n.statsUpdated = time.Now()
+
+	n.releaseHeld(nodeStats, n.statsUpdated)
+}
+
+// CurrentStats returns the stats last set on the node, or why they couldn't be fetched.
+func (n *Node) CurrentStats() (*stats.Stats, error) {
+	if n.client == nil {
+		return &n.Stats, nil
+	}
+
+	if n.statsErr != nil {
+		return nil, n.statsErr
+	}
+
+	if n.statsUpdated.IsZero() {
+		return nil, fmt.Errorf("no stats from node %s yet", n.Name)
+	}
 
 	return &n.Stats, nil
 }
 
+// AllTaints returns the agent and server taints of the node.
+func (n *Node) AllTaints() []task.Taint {
+	return append(slices.Clone(n.Taints), n.ServerTaints...)
+}
+
+// Allocate reserves resources for a task being dispatched to the node, so concurrent dispatches don't overcommit it.
+func (n *Node) Allocate(cpu float64, memory int64, disk int64) {
+	n.allocMu.Lock()
+	defer n.allocMu.Unlock()
+
+	n.CpuAllocated += cpu
+	n.MemoryAllocated += memory
+	n.DiskAllocated += disk
+}
+
+// Release returns resources reserved by Allocate.
+func (n *Node) Release(cpu float64, memory int64, disk int64) {
+	n.allocMu.Lock()
+	defer n.allocMu.Unlock()
+
+	n.release(cpu, memory, disk)
+}
+
+func (n *Node) release(cpu float64, memory int64, disk int64) {
+	n.CpuAllocated = max(n.CpuAllocated-cpu, 0)
+	n.MemoryAllocated = max(n.MemoryAllocated-memory, 0)
+	n.DiskAllocated = max(n.DiskAllocated-disk, 0)
+}
+
+// Hold keeps resources reserved by Allocate for a torrent the agent accepted, until its stats report the torrent
+// downloading, which then counts its remaining bytes itself, or holdTimeout passes.
+func (n *Node) Hold(hash string, cpu float64, memory int64, disk int64) {
+	n.allocMu.Lock()
+	defer n.allocMu.Unlock()
+
+	if n.held == nil {
+		n.held = map[string]allocation{}
+	}
+
+	key := strings.ToLower(hash)
+
+	// the same torrent sent again replaces its hold
+	if prev, ok := n.held[key]; ok {
+		n.release(prev.cpu, prev.memory, prev.disk)
+	}
+
+	n.held[key] = allocation{cpu: cpu, memory: memory, disk: disk, until: time.Now().Add(holdTimeout)}
+}
+
+// releaseHeld releases the holds of torrents reported by the stats, and of ones held for longer than holdTimeout.
+func (n *Node) releaseHeld(nodeStats *stats.Stats, now time.Time) {
+	n.allocMu.Lock()
+	defer n.allocMu.Unlock()
+
+	for hash, a := range n.held {
+		if now.Before(a.until) && !nodeStats.HasDownload(hash) {
+			continue
+		}
+
+		n.release(a.cpu, a.memory, a.disk)
+		delete(n.held, hash)
+	}
+}
+
+// Allocated returns the resources reserved for in-flight tasks.
+func (n *Node) Allocated() (cpu float64, memory int64, disk int64) {
+	n.allocMu.Lock()
+	defer n.allocMu.Unlock()
+
+	return n.CpuAllocated, n.MemoryAllocated, n.DiskAllocated
+}
+
 func (n *Node) GetLabels(ctx context.Context) (map[string]string, error) {
-	if n.Labels != nil {
+	if n.Labels != nil || n.client == nil {
 		return n.Labels, nil
 	}
 
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"context"
+	"math"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+)
+
+// defaultTaskCpu is the load a single download adds when the task has no cpu request
+const defaultTaskCpu = 0.1
+
+// Epvm implements the Enhanced Parallel Virtual Machine scheduler. It places tasks where they
+// add the least marginal cost, where the cost of a resource grows exponentially with its usage.
+type Epvm struct {
+	Name string
+}
+
+func (e *Epvm) SelectCandidateNodes(ctx context.Context, t task.Task, nodes []*node.Node) []*node.Node {
+	return selectCandidateNodes(ctx, t, nodes)
+}
+
+// Score returns the negated marginal cost of the task on each node, so the cheapest node scores highest.
+func (e *Epvm) Score(ctx context.Context, t task.Task, nodes []*node.Node) map[string]float64 {
+	return scores(e.Explain(ctx, t, nodes))
+}
+
+func (e *Epvm) Explain(ctx context.Context, t task.Task, nodes []*node.Node) map[string]Breakdown {
+	breakdowns := make(map[string]Breakdown)
+
+	for _, n := range nodes {
+		b := Breakdown{}
+		for _, cost := range marginalCosts(t, n) {
+			b.add(cost.Name, -cost.Value)
+		}
+		b.addPlacement(ctx, t, n)
+
+		breakdowns[n.Name] = b
+	}
+
+	return breakdowns
+}
+
+func (e *Epvm) Pick(scores map[string]float64, candidates []*node.Node) []*node.Node {
+	return pickN(scores, candidates, 1)
+}
+
+func (e *Epvm) PickN(scores map[string]float64, candidates []*node.Node, number int) []*node.Node {
+	return pickN(scores, candidates, number)
+}
+
+// marginalCosts returns LIEB^after - LIEB^before for memory, disk, download slots and load,
+// for every resource the node reported stats for.
+func marginalCosts(t task.Task, n *node.Node) []ScorePart {
+	var costs []ScorePart
+
+	if before, ok := memUsage(n, 0); ok {
+		after, _ := memUsage(n, t.Memory)
+		costs = append(costs, ScorePart{Name: "memory_cost", Value: resourceCost(before, after)})
+	}
+
+	if before, ok := diskUsage(n, 0); ok {
+		after, _ := diskUsage(n, t.DiskRequest())
+		costs = append(costs, ScorePart{Name: "disk_cost", Value: resourceCost(before, after)})
+	}
+
+	if before, ok := slotUsage(n, 0); ok {
+		after, _ := slotUsage(n, 1)
+		costs = append(costs, ScorePart{Name: "slot_cost", Value: resourceCost(before, after)})
+	}
+
+	cpu := t.Cpu
+	if cpu <= 0 {
+		cpu = defaultTaskCpu
+	}
+
+	// map the unbounded load average to 0..1
+	load := loadAvg(n)
+	costs = append(costs, ScorePart{Name: "load_cost", Value: resourceCost(load/(1+load), (load+cpu)/(1+load+cpu))})
+
+	return costs
+}
+
+func resourceCost(before, after float64) float64 {
+	return math.Pow(LIEB, after) - math.Pow(LIEB, before)
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+)
+
+// Explainer is implemented by schedulers that can break their scores down into parts.
+// Unlike Score, Explain has no side effects, like advancing a rotation.
+type Explainer interface {
+	Explain(ctx context.Context, t task.Task, nodes []*node.Node) map[string]Breakdown
+}
+
+// ScorePart is a named contribution to a node score.
+type ScorePart struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// Breakdown is a node score and the parts it adds up from, in the order they were added.
+type Breakdown struct {
+	Score float64     `json:"score"`
+	Parts []ScorePart `json:"parts"`
+}
+
+func (b *Breakdown) add(name string, value float64) {
+	b.Score += value
+
+	for i := range b.Parts {
+		if b.Parts[i].Name == name {
+			b.Parts[i].Value += value
+			return
+		}
+	}
+
+	b.Parts = append(b.Parts, ScorePart{Name: name, Value: value})
+}
+
+// addPlacement adds the parts shared by every scheduler: preferred label weights and taint penalties
+func (b *Breakdown) addPlacement(ctx context.Context, t task.Task, n *node.Node) {
+	b.add("preferred_labels", preferenceScore(ctx, t.PreferredLabels, n))
+	b.add("taints", -taintPenalty(t, n))
+}
+
+// scores returns the total score of every node
+func scores(breakdowns map[string]Breakdown) map[string]float64 {
+	nodeScores := make(map[string]float64, len(breakdowns))
+	for name, b := range breakdowns {
+		nodeScores[name] = b.Score
+	}
+
+	return nodeScores
+}
@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+)
+
+// Greedy places tasks on the nodes with the most free memory and disk, and the lowest load, right now.
+type Greedy struct {
+	Name string
+}
+
+func (g *Greedy) SelectCandidateNodes(ctx context.Context, t task.Task, nodes []*node.Node) []*node.Node {
+	return selectCandidateNodes(ctx, t, nodes)
+}
+
+// Score adds up free memory, free disk, storage headroom for the release and an inverse of the load, each between 0 and 1.
+func (g *Greedy) Score(ctx context.Context, t task.Task, nodes []*node.Node) map[string]float64 {
+	return scores(g.Explain(ctx, t, nodes))
+}
+
+func (g *Greedy) Explain(ctx context.Context, t task.Task, nodes []*node.Node) map[string]Breakdown {
+	breakdowns := make(map[string]Breakdown)
+
+	for _, n := range nodes {
+		b := Breakdown{}
+		b.add("load", 1/(1+loadAvg(n)))
+
+		if usage, ok := memUsage(n, 0); ok {
+			b.add("memory_free", clamp(1-usage))
+		}
+
+		if usage, ok := diskUsage(n, 0); ok {
+			b.add("disk_free", clamp(1-usage))
+		}
+
+		b.add("headroom", headroomRatio(t, n)/maxHeadroomRatio)
+		b.addPlacement(ctx, t, n)
+
+		breakdowns[n.Name] = b
+	}
+
+	return breakdowns
+}
+
+func (g *Greedy) Pick(scores map[string]float64, candidates []*node.Node) []*node.Node {
+	return pickN(scores, candidates, 1)
+}
+
+func (g *Greedy) PickN(scores map[string]float64, candidates []*node.Node, number int) []*node.Node {
+	return pickN(scores, candidates, number)
+}
+
+func clamp(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
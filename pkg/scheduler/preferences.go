@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/rs/zerolog/log"
+)
+
+// preferenceScore sums the weights of the preferred labels the node has
+func preferenceScore(ctx context.Context, preferred []task.PreferredLabel, n *node.Node) float64 {
+	if len(preferred) == 0 {
+		return 0
+	}
+
+	nodeLabels, err := n.GetLabels(ctx)
+	if err != nil {
+		log.Error().Err(err).Msgf("could not get labels for node %s", n.Name)
+		return 0
+	}
+
+	score := 0.0
+	for _, p := range preferred {
+		value, ok := nodeLabels[p.Key]
+		if !ok || (p.Value != "" && p.Value != value) {
+			continue
+		}
+
+		score += p.Weight
+	}
+
+	return score
+}
@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultScheduler = "leastactive"
+
+var ErrUnknownScheduler = errors.New("unknown scheduler")
+
+// Factory creates a new scheduler instance
+type Factory func() Scheduler
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+func init() {
+	Register(DefaultScheduler, func() Scheduler { return &LeastActive{Name: DefaultScheduler} })
+	Register("roundrobin", func() Scheduler { return &RoundRobin{Name: "roundrobin", LastWorker: -1} })
+	Register("greedy", func() Scheduler { return &Greedy{Name: "greedy"} })
+	Register("epvm", func() Scheduler { return &Epvm{Name: "epvm"} })
+}
+
+// Register makes a scheduler available by name. Names are case-insensitive.
+// It panics if the name is registered twice.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	name = normalizeName(name)
+
+	if factory == nil {
+		panic("scheduler: Register factory is nil for " + name)
+	}
+
+	if _, dup := registry[name]; dup {
+		panic("scheduler: Register called twice for " + name)
+	}
+
+	registry[name] = factory
+}
+
+// New creates the scheduler registered with name.
+func New(name string) (Scheduler, error) {
+	registryMu.RLock()
+	factory, ok := registry[normalizeName(name)]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownScheduler, "%q", name)
+	}
+
+	return factory(), nil
+}
+
+// Exists reports if a scheduler is registered with name.
+func Exists(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	_, ok := registry[normalizeName(name)]
+	return ok
+}
+
+// Names returns the registered scheduler names, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
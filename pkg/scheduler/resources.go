@@ -0,0 +1,266 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/stats"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// MemStats are reported in kB
+	kb = 1024
+
+	// maxHeadroomRatio caps the storage headroom bonus, room for this many more releases of the same size is plenty
+	maxHeadroomRatio = 10.0
+)
+
+// memUsage returns the fraction of memory in use on the node, including memory allocated
+// to in-flight tasks and extra bytes on top. ok is false when the node reported no memory stats.
+func memUsage(n *node.Node, extra int64) (usage float64, ok bool) {
+	ms := n.Stats.MemStats
+	if ms == nil || ms.MemTotal == 0 {
+		return 0, false
+	}
+
+	var used uint64
+	if ms.MemTotal > ms.MemAvailable {
+		used = ms.MemTotal - ms.MemAvailable
+	}
+
+	_, allocated, _ := n.Allocated()
+
+	total := float64(ms.MemTotal) * kb
+
+	return (float64(used)*kb + float64(allocated+extra)) / total, true
+}
+
+// diskSpace returns the disk downloads land on: the client storage path with the most free space,
+// or the root filesystem when no client reported storage paths. ok is false when neither is known.
+func diskSpace(n *node.Node) (total uint64, used uint64, free uint64, ok bool) {
+	for _, cs := range n.Stats.ClientStats {
+		for _, path := range cs.Storage {
+			// unreadable paths are reported without a size
+			if path.Total == 0 {
+				continue
+			}
+
+			if !ok || path.Free > free {
+				total, used, free, ok = path.Total, path.Used, path.Free, true
+			}
+		}
+	}
+
+	if ok {
+		return total, used, free, true
+	}
+
+	ds := n.Stats.DiskStats
+	if ds == nil || ds.All == 0 {
+		return 0, 0, 0, false
+	}
+
+	return ds.All, ds.Used, ds.Free, true
+}
+
+// diskReserved returns the disk allocated to in-flight tasks plus the bytes the active downloads still need.
+func diskReserved(n *node.Node) int64 {
+	_, _, allocated := n.Allocated()
+
+	for _, cs := range n.Stats.ClientStats {
+		allocated += cs.RemainingBytes()
+	}
+
+	return allocated
+}
+
+// diskUsage returns the fraction of disk in use on the node, including disk reserved
+// for in-flight tasks and active downloads, and extra bytes on top. ok is false when the node reported no disk stats.
+func diskUsage(n *node.Node, extra int64) (usage float64, ok bool) {
+	total, used, _, ok := diskSpace(n)
+	if !ok {
+		return 0, false
+	}
+
+	return (float64(used) + float64(diskReserved(n)+extra)) / float64(total), true
+}
+
+// diskFree returns the free disk on the node minus disk reserved for in-flight tasks and active downloads.
+// ok is false when the node reported no disk stats.
+func diskFree(n *node.Node) (free int64, ok bool) {
+	_, _, diskFree, ok := diskSpace(n)
+	if !ok {
+		return 0, false
+	}
+
+	return int64(diskFree) - diskReserved(n), true
+}
+
+// memFree returns the available memory in bytes on the node minus memory allocated to in-flight tasks.
+// ok is false when the node reported no memory stats.
+func memFree(n *node.Node) (free int64, ok bool) {
+	ms := n.Stats.MemStats
+	if ms == nil || ms.MemTotal == 0 {
+		return 0, false
+	}
+
+	_, allocated, _ := n.Allocated()
+
+	return int64(ms.MemAvailable)*kb - allocated, true
+}
+
+// cpuFree returns the cores on the node not used by the load average or allocated to in-flight tasks.
+// ok is false when the node reported no cpu count.
+func cpuFree(n *node.Node) (free float64, ok bool) {
+	if n.Stats.CpuCount == 0 {
+		return 0, false
+	}
+
+	allocated, _, _ := n.Allocated()
+
+	return float64(n.Stats.CpuCount) - loadAvg(n) - allocated, true
+}
+
+// fitReason checks that the node has room for the task cpu, memory and disk requests, and that the disk request
+// fits the agent storage rules. It returns which request doesn't fit, or "" if they all fit.
+// Requests are skipped when the node didn't report the matching stats.
+func fitReason(t task.Task, n *node.Node) string {
+	if t.Cpu > 0 {
+		if free, ok := cpuFree(n); ok && free < t.Cpu {
+			return fmt.Sprintf("not enough cpu: %.2f cores requested, %.2f free", t.Cpu, free)
+		}
+	}
+
+	if t.Memory > 0 {
+		if free, ok := memFree(n); ok && free < t.Memory {
+			return fmt.Sprintf("not enough memory: %s requested, %s free", humanize.IBytes(uint64(t.Memory)), humanizeSigned(free))
+		}
+	}
+
+	if need := t.DiskRequest(); need > 0 {
+		if free, ok := diskFree(n); ok && free < need {
+			return fmt.Sprintf("not enough disk: %s requested, %s free", humanize.IBytes(uint64(need)), humanizeSigned(free))
+		}
+
+		if _, fits, ok := storageHeadroom(n, need); ok && !fits {
+			return fmt.Sprintf("no storage path fits %s within the agent storage rules", humanize.IBytes(uint64(need)))
+		}
+	}
+
+	return ""
+}
+
+// humanizeSigned formats bytes that can be negative once allocations are subtracted
+func humanizeSigned(b int64) string {
+	if b < 0 {
+		return "-" + humanize.IBytes(uint64(-b))
+	}
+
+	return humanize.IBytes(uint64(b))
+}
+
+// slotUsage returns the fraction of download slots in use across the node clients, with extra downloads on top.
+// ok is false when no client has a limit.
+func slotUsage(n *node.Node, extra int) (usage float64, ok bool) {
+	active, allowed := 0, 0
+	for _, cs := range n.Stats.ClientStats {
+		active += cs.ActiveDownloadsCount
+		allowed += cs.MaxActiveDownloadsAllowed
+	}
+
+	if allowed == 0 {
+		return 0, false
+	}
+
+	return float64(active+extra) / float64(allowed), true
+}
+
+// loadAvg returns the 1 minute load average of the node, 0 if unknown.
+func loadAvg(n *node.Node) float64 {
+	if n.Stats.LoadStats == nil {
+		return 0
+	}
+
+	return n.Stats.LoadStats.Last1Min
+}
+
+// storageHeadroom returns how many bytes the node has left under its agent storage rules after adding size,
+// using the best path of each client and the tightest client, since the agent adds torrents to every client.
+// Disk allocated to in-flight tasks and the bytes the client active downloads still need count as used.
+// fits is false when a client has no storage path that can take size without going below minFree or above maxUsage.
+// ok is false when no client reported storage rules.
+func storageHeadroom(n *node.Node, size int64) (headroom int64, fits bool, ok bool) {
+	_, _, allocated := n.Allocated()
+
+	first := true
+
+	for _, cs := range n.Stats.ClientStats {
+		if len(cs.Storage) == 0 {
+			continue
+		}
+
+		ok = true
+
+		need := size + allocated + cs.RemainingBytes()
+
+		best, clientFits := int64(0), false
+		for _, path := range cs.Storage {
+			room, pathFits := pathHeadroom(path, need)
+			if !pathFits {
+				continue
+			}
+
+			if !clientFits || room > best {
+				best = room
+			}
+			clientFits = true
+		}
+
+		if !clientFits {
+			return 0, false, true
+		}
+
+		if first || best < headroom {
+			headroom = best
+		}
+		first = false
+	}
+
+	return headroom, true, ok
+}
+
+// pathHeadroom returns the bytes left on the path after adding need, before hitting its minFree or maxUsage.
+func pathHeadroom(path stats.StorageStats, need int64) (int64, bool) {
+	// unreadable paths are reported without a size
+	if path.Total == 0 {
+		return 0, false
+	}
+
+	room := int64(path.Free) - need - int64(path.MinFree)
+
+	if path.MaxUsage > 0 {
+		room = min(room, int64(path.MaxUsage)-int64(path.Used)-need)
+	}
+
+	return room, room >= 0
+}
+
+// headroomRatio returns the storage headroom relative to the release size, capped at maxHeadroomRatio.
+// It is 0 when the size or the storage rules are unknown.
+func headroomRatio(t task.Task, n *node.Node) float64 {
+	size := t.DiskRequest()
+	if size <= 0 {
+		return 0
+	}
+
+	headroom, fits, ok := storageHeadroom(n, size)
+	if !ok || !fits {
+		return 0
+	}
+
+	return math.Min(float64(headroom)/float64(size), maxHeadroomRatio)
+}
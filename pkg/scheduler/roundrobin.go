@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+)
+
+// RoundRobin rotates through the candidates, one step per task.
+type RoundRobin struct {
+	Name       string
+	LastWorker int
+
+	m sync.Mutex
+}
+
+func (r *RoundRobin) SelectCandidateNodes(ctx context.Context, t task.Task, nodes []*node.Node) []*node.Node {
+	return selectCandidateNodes(ctx, t, nodes)
+}
+
+// Score gives the next node in the rotation the highest score, and the ones after it
+// decreasing scores so replicas continue the rotation.
+func (r *RoundRobin) Score(ctx context.Context, t task.Task, nodes []*node.Node) map[string]float64 {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	nodeScores := scores(r.breakdown(ctx, t, nodes))
+
+	if len(nodes) > 0 {
+		r.LastWorker = (r.LastWorker + 1) % len(nodes)
+	}
+
+	return nodeScores
+}
+
+// Explain returns the scores of the next task without advancing the rotation.
+func (r *RoundRobin) Explain(ctx context.Context, t task.Task, nodes []*node.Node) map[string]Breakdown {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.breakdown(ctx, t, nodes)
+}
+
+// breakdown scores the nodes from the current rotation. Callers must hold r.m
+func (r *RoundRobin) breakdown(ctx context.Context, t task.Task, nodes []*node.Node) map[string]Breakdown {
+	breakdowns := make(map[string]Breakdown)
+	if len(nodes) == 0 {
+		return breakdowns
+	}
+
+	next := (r.LastWorker + 1) % len(nodes)
+
+	for i, n := range nodes {
+		offset := (i - next + len(nodes)) % len(nodes)
+
+		b := Breakdown{}
+		b.add("rotation", float64(len(nodes)-offset))
+		b.addPlacement(ctx, t, n)
+
+		breakdowns[n.Name] = b
+	}
+
+	return breakdowns
+}
+
+func (r *RoundRobin) Pick(scores map[string]float64, candidates []*node.Node) []*node.Node {
+	return pickN(scores, candidates, 1)
+}
+
+func (r *RoundRobin) PickN(scores map[string]float64, candidates []*node.Node, number int) []*node.Node {
+	return pickN(scores, candidates, number)
+}
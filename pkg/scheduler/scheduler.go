@@ -2,7 +2,10 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
+	"maps"
 	"math"
+	"slices"
 	"sort"
 
 	"github.com/autobrr/distribrr/pkg/node"
@@ -17,12 +20,17 @@ const (
 	// LIEB square ice constant
 	// https://en.wikipedia.org/wiki/Lieb%27s_square_ice_constant
 	LIEB = 1.53960071783900203869
+
+	// backfillReservedSlots is the number of download slots per client kept free of backfill tasks
+	backfillReservedSlots = 1
 )
 
+// Scheduler places tasks on nodes. Higher scores are better.
 type Scheduler interface {
 	SelectCandidateNodes(ctx context.Context, t task.Task, nodes []*node.Node) []*node.Node
 	Score(ctx context.Context, t task.Task, nodes []*node.Node) map[string]float64
 	Pick(scores map[string]float64, candidates []*node.Node) []*node.Node
+	PickN(scores map[string]float64, candidates []*node.Node, number int) []*node.Node
 }
 
 type LeastActive struct {
@@ -31,43 +39,181 @@ type LeastActive struct {
 }
 
 func (r *LeastActive) SelectCandidateNodes(ctx context.Context, t task.Task, nodes []*node.Node) []*node.Node {
+	return selectCandidateNodes(ctx, t, nodes)
+}
+
+// selectCandidateNodes applies the checks shared by all schedulers: pinned nodes, node health,
+// labels and client readiness.
+func selectCandidateNodes(ctx context.Context, t task.Task, nodes []*node.Node) []*node.Node {
+	candidates, _ := Filter(ctx, t, nodes)
+	return candidates
+}
+
+// Filter returns the nodes that pass the checks shared by all schedulers, and why every other node was filtered out.
+// Nodes are checked against the stats last set on them, callers fetch fresh ones first.
+func Filter(ctx context.Context, t task.Task, nodes []*node.Node) ([]*node.Node, map[string]string) {
+	reasons := map[string]string{}
+
+	if len(t.Nodes) == 0 {
+		return filterNodes(ctx, t, nodes, reasons), reasons
+	}
+
+	candidates := filterNodes(ctx, t, pinnedNodes(t.Nodes, nodes), reasons)
+	if len(candidates) == 0 && t.NodesFallback {
+		log.Debug().Msgf("pinned nodes %v not ready for task %s, falling back to all nodes", t.Nodes, t.Name)
+		return filterNodes(ctx, t, nodes, reasons), reasons
+	}
+
+	for _, n := range nodes {
+		if !slices.Contains(t.Nodes, n.Name) {
+			reasons[n.Name] = fmt.Sprintf("not one of the pinned nodes %v", t.Nodes)
+		}
+	}
+
+	return candidates, reasons
+}
+
+// filterNodes returns the candidate nodes, and records why the others were filtered out in reasons
+func filterNodes(ctx context.Context, t task.Task, nodes []*node.Node, reasons map[string]string) []*node.Node {
 	var candidates []*node.Node
 
-nodeLoop:
+	selector, err := ParseSelector(t.Selectors)
+	if err != nil {
+		log.Error().Err(err).Msgf("could not parse selectors for task %s", t.Name)
+
+		for _, n := range nodes {
+			reasons[n.Name] = err.Error()
+		}
+
+		return nil
+	}
+
 	for _, n := range nodes {
-		if n.Status != node.StatusReady {
+		if reason := checkNode(ctx, t, n, selector); reason != "" {
+			log.Trace().Msgf("node %s filtered out for task %s: %s", n.Name, t.Name, reason)
+			reasons[n.Name] = reason
 			continue
 		}
 
-		// match nodes by labels
-		nodeLabels, err := n.GetLabels(ctx)
-		if err != nil {
-			log.Error().Err(err).Msgf("could not get labels for node %s", n.Name)
-			continue
+		delete(reasons, n.Name)
+		candidates = append(candidates, n)
+	}
+
+	return candidates
+}
+
+// CheckNode returns why the node can't take the task right now, or "" if it can. Like filtering it uses the stats
+// last set on the node and counts resources allocated to in-flight tasks, so nodes ranked a while ago can be
+// checked again.
+func CheckNode(ctx context.Context, t task.Task, n *node.Node) string {
+	selector, err := ParseSelector(t.Selectors)
+	if err != nil {
+		return err.Error()
+	}
+
+	return checkNode(ctx, t, n, selector)
+}
+
+// checkNode returns why the node can't take the task, or "" if it can
+func checkNode(ctx context.Context, t task.Task, n *node.Node, selector []Requirement) string {
+	if n.Status != node.StatusReady {
+		return fmt.Sprintf("node status is %s", n.Status)
+	}
+
+	// match nodes by labels
+	nodeLabels, err := n.GetLabels(ctx)
+	if err != nil {
+		log.Error().Err(err).Msgf("could not get labels for node %s", n.Name)
+		return fmt.Sprintf("could not get labels: %v", err)
+	}
+
+	if !checkLabels(t.Labels, nodeLabels) {
+		return labelMismatch(t.Labels, nodeLabels)
+	}
+
+	for _, req := range selector {
+		if !req.Matches(nodeLabels) {
+			return fmt.Sprintf("selector %q doesn't match", req.String())
 		}
+	}
 
-		if !checkLabels(t.Labels, nodeLabels) {
-			continue
+	if taint, ok := untoleratedTaint(t, n); ok {
+		return fmt.Sprintf("taint %s is not tolerated", taint)
+	}
+
+	stat, err := n.CurrentStats()
+	if err != nil {
+		log.Error().Err(err).Msgf("could not get stats for node %s", n.Name)
+		return fmt.Sprintf("could not get stats: %v", err)
+	}
+
+	if reason := fitReason(t, n); reason != "" {
+		log.Debug().Msgf("node %s can't fit the resource request of task %s", n.Name, t.Name)
+		return reason
+	}
+
+	// force added tasks go to any healthy node that matches and fits, even if its clients are full
+	if t.ForceAdd {
+		return ""
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(stat.ClientStats)) {
+		clientStats := stat.ClientStats[name]
+
+		if clientStats.Status != stats.ClientStatusReady {
+			return fmt.Sprintf("client %s is not ready: %s, %d/%d active downloads", name, clientStats.Status, clientStats.ActiveDownloadsCount, clientStats.MaxActiveDownloadsAllowed)
 		}
 
-		// TODO check available disk
+		if !hasSlotFor(t.Priority, clientStats) {
+			return fmt.Sprintf("client %s has no free slot for %s tasks", name, task.PriorityBackfill)
+		}
+	}
 
-		stat, err := n.GetStats(ctx)
-		if err != nil {
-			log.Error().Err(err).Msgf("could not get stats for node %s", n.Name)
-			continue
+	return ""
+}
+
+// pinnedNodes returns the nodes named in the task, in the original node order
+func pinnedNodes(names []string, nodes []*node.Node) []*node.Node {
+	var pinned []*node.Node
+	for _, n := range nodes {
+		if slices.Contains(names, n.Name) {
+			pinned = append(pinned, n)
 		}
+	}
 
-		for _, clientStats := range stat.ClientStats {
-			if clientStats.Status != stats.ClientStatusReady {
-				continue nodeLoop
-			}
+	return pinned
+}
+
+// hasSlotFor checks if the client has a download slot for a task of this priority.
+// Backfill tasks never take the last free slots, so critical and normal tasks can still land.
+// Clients without more slots than are reserved don't keep any, so backfill isn't starved on them.
+func hasSlotFor(priority task.Priority, clientStats stats.ClientStats) bool {
+	if priority.OrDefault() != task.PriorityBackfill || clientStats.MaxActiveDownloadsAllowed <= 0 {
+		return true
+	}
+
+	allowed := clientStats.MaxActiveDownloadsAllowed
+	if allowed > backfillReservedSlots {
+		allowed -= backfillReservedSlots
+	}
+
+	return clientStats.ActiveDownloadsCount < allowed
+}
+
+// labelMismatch describes the first task label the node doesn't have
+func labelMismatch(taskLabels map[string]string, nodeLabels map[string]string) string {
+	for _, key := range slices.Sorted(maps.Keys(taskLabels)) {
+		v, ok := nodeLabels[key]
+		if !ok {
+			return fmt.Sprintf("label %s=%s is missing", key, taskLabels[key])
 		}
 
-		candidates = append(candidates, n)
+		if v != taskLabels[key] {
+			return fmt.Sprintf("label %s=%s doesn't match %s=%s", key, taskLabels[key], key, v)
+		}
 	}
 
-	return candidates
+	return ""
 }
 
 // checkLabels match nodes by labels
@@ -83,31 +229,39 @@ func checkLabels(taskLabels map[string]string, nodeLabels map[string]string) boo
 }
 
 func (r *LeastActive) Score(ctx context.Context, t task.Task, nodes []*node.Node) map[string]float64 {
-	nodeScores := make(map[string]float64)
+	return scores(r.Explain(ctx, t, nodes))
+}
+
+func (r *LeastActive) Explain(ctx context.Context, t task.Task, nodes []*node.Node) map[string]Breakdown {
+	breakdowns := make(map[string]Breakdown)
 	baseScore := 100.0    // Start with a high base score
 	noActiveBonus := 20.0 // Bonus for having no active downloads
+	headroomBonus := 1.0  // Bonus per release of storage headroom, up to maxHeadroomRatio
 
 	for _, n := range nodes {
-		score := baseScore
+		b := Breakdown{}
+		b.add("base", baseScore)
+		b.add("headroom", headroomBonus*headroomRatio(t, n))
+		b.addPlacement(ctx, t, n)
 
 		for _, clientStats := range n.Stats.ClientStats {
 			if clientStats.ActiveDownloadsCount == 0 {
 				// Bonus for having no active downloads
-				score += noActiveBonus
+				b.add("no_active_downloads", noActiveBonus)
 				continue
 			}
 
 			// Calculate penalties for each active download
 			for _, torrent := range clientStats.ActiveDownloads {
 				penalty := calculateTorrentPenalty(torrent)
-				score -= penalty
+				b.add("active_downloads", -penalty)
 			}
 		}
 
-		nodeScores[n.Name] = score
+		breakdowns[n.Name] = b
 	}
 
-	return nodeScores
+	return breakdowns
 }
 
 // calculateTorrentPenalty determines the penalty for a single torrent based on its progress and ETA
@@ -150,6 +304,11 @@ func (r *LeastActive) Pick(scores map[string]float64, candidates []*node.Node) [
 }
 
 func (r *LeastActive) PickN(scores map[string]float64, candidates []*node.Node, number int) []*node.Node {
+	return pickN(scores, candidates, number)
+}
+
+// pickN returns the number highest scoring candidates. It sorts candidates in place.
+func pickN(scores map[string]float64, candidates []*node.Node, number int) []*node.Node {
 	if len(candidates) == 0 {
 		return nil
 	}
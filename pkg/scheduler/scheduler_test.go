@@ -2,12 +2,15 @@ package scheduler
 
 import (
 	"context"
+	"math"
+	"slices"
 	"testing"
 
 	"github.com/autobrr/distribrr/pkg/node"
 	"github.com/autobrr/distribrr/pkg/stats"
 	"github.com/autobrr/distribrr/pkg/task"
 	"github.com/autobrr/go-qbittorrent"
+	"github.com/c9s/goprocinfo/linux"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -248,3 +251,775 @@ func TestLeastActive_PickN(t *testing.T) {
 		assert.Equal(t, "node1", got[0].Name)
 	})
 }
+
+func Test_hasSlotFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority task.Priority
+		active   int
+		max      int
+		want     bool
+	}{
+		{name: "normal takes last slot", priority: task.PriorityNormal, active: 2, max: 3, want: true},
+		{name: "unset takes last slot", priority: "", active: 2, max: 3, want: true},
+		{name: "critical takes last slot", priority: task.PriorityCritical, active: 2, max: 3, want: true},
+		{name: "backfill leaves last slot", priority: task.PriorityBackfill, active: 2, max: 3, want: false},
+		{name: "backfill with room", priority: task.PriorityBackfill, active: 1, max: 3, want: true},
+		{name: "backfill without limit", priority: task.PriorityBackfill, active: 5, max: 0, want: true},
+		{name: "backfill on idle single slot client", priority: task.PriorityBackfill, active: 0, max: 1, want: true},
+		{name: "backfill on busy single slot client", priority: task.PriorityBackfill, active: 1, max: 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := stats.ClientStats{ActiveDownloadsCount: tt.active, MaxActiveDownloadsAllowed: tt.max}
+			assert.Equal(t, tt.want, hasSlotFor(tt.priority, cs))
+		})
+	}
+}
+
+func Test_pinnedNodes(t *testing.T) {
+	nodes := []*node.Node{{Name: "node0"}, {Name: "node1"}, {Name: "node2"}}
+
+	got := pinnedNodes([]string{"node2", "node0", "missing"}, nodes)
+	assert.Len(t, got, 2)
+	assert.Equal(t, "node0", got[0].Name)
+	assert.Equal(t, "node2", got[1].Name)
+
+	assert.Empty(t, pinnedNodes([]string{"missing"}, nodes))
+}
+
+func TestRegistry(t *testing.T) {
+	assert.True(t, Exists("leastactive"))
+	assert.True(t, Exists(" LeastActive "))
+	assert.Contains(t, Names(), DefaultScheduler)
+
+	sc, err := New("LEASTACTIVE")
+	assert.NoError(t, err)
+	assert.IsType(t, &LeastActive{}, sc)
+
+	_, err = New("missing")
+	assert.ErrorIs(t, err, ErrUnknownScheduler)
+
+	assert.Panics(t, func() {
+		Register(DefaultScheduler, func() Scheduler { return &LeastActive{} })
+	})
+}
+
+func newResourceNode(name string, memAvailableKb uint64, diskFree uint64, load float64, active int) *node.Node {
+	return &node.Node{
+		Name:   name,
+		Status: node.StatusReady,
+		Stats: stats.Stats{
+			MemStats:  &linux.MemInfo{MemTotal: 8 * 1024 * 1024, MemAvailable: memAvailableKb},
+			DiskStats: &linux.Disk{All: 1000 << 30, Free: diskFree, Used: 1000<<30 - diskFree},
+			LoadStats: &linux.LoadAvg{Last1Min: load},
+			ClientStats: map[string]stats.ClientStats{
+				"qbit": {ActiveDownloadsCount: active, MaxActiveDownloadsAllowed: 4, Status: stats.ClientStatusReady},
+			},
+		},
+	}
+}
+
+func TestRoundRobin_Score(t *testing.T) {
+	nodes := []*node.Node{{Name: "node0"}, {Name: "node1"}, {Name: "node2"}}
+
+	r := &RoundRobin{LastWorker: -1}
+
+	var picked []string
+	for range 4 {
+		scores := r.Score(context.Background(), task.Task{}, nodes)
+		picked = append(picked, r.PickN(scores, slices.Clone(nodes), 1)[0].Name)
+	}
+
+	assert.Equal(t, []string{"node0", "node1", "node2", "node0"}, picked)
+
+	t.Run("replicas continue the rotation", func(t *testing.T) {
+		r := &RoundRobin{LastWorker: 1}
+
+		scores := r.Score(context.Background(), task.Task{}, nodes)
+		got := r.PickN(scores, slices.Clone(nodes), 2)
+
+		assert.Equal(t, "node2", got[0].Name)
+		assert.Equal(t, "node0", got[1].Name)
+	})
+
+	t.Run("no nodes", func(t *testing.T) {
+		assert.Empty(t, r.Score(context.Background(), task.Task{}, nil))
+	})
+}
+
+func TestGreedy_Score(t *testing.T) {
+	idle := newResourceNode("idle", 7*1024*1024, 900<<30, 0.1, 0)
+	busy := newResourceNode("busy", 1*1024*1024, 100<<30, 4, 3)
+	unknown := &node.Node{Name: "unknown"}
+
+	g := &Greedy{}
+	scores := g.Score(context.Background(), task.Task{}, []*node.Node{busy, idle, unknown})
+
+	assert.Greater(t, scores["idle"], scores["busy"])
+	assert.InDelta(t, 1.0, scores["unknown"], 0.0001)
+
+	got := g.PickN(scores, []*node.Node{busy, idle}, 1)
+	assert.Equal(t, "idle", got[0].Name)
+}
+
+func Test_fitReason_requests(t *testing.T) {
+	n := newResourceNode("node", 4*1024*1024, 100<<30, 1, 0)
+	n.Stats.CpuCount = 4
+
+	tests := []struct {
+		name string
+		task task.Task
+		want bool
+	}{
+		{name: "no requests", task: task.Task{}, want: true},
+		{name: "disk fits", task: task.Task{Disk: 50 << 30}, want: true},
+		{name: "disk too large", task: task.Task{Disk: 200 << 30}, want: false},
+		{name: "disk defaults to size", task: task.Task{Size: 200 << 30}, want: false},
+		{name: "disk request wins over size", task: task.Task{Size: 200 << 30, Disk: 1 << 30}, want: true},
+		{name: "memory fits", task: task.Task{Memory: 1 << 30}, want: true},
+		{name: "memory too large", task: task.Task{Memory: 8 << 30}, want: false},
+		{name: "cpu fits", task: task.Task{Cpu: 2}, want: true},
+		{name: "cpu too large", task: task.Task{Cpu: 3.5}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fitReason(tt.task, n) == "")
+		})
+	}
+
+	t.Run("allocations count as used", func(t *testing.T) {
+		tsk := task.Task{Cpu: 1, Memory: 1 << 30, Disk: 40 << 30}
+		assert.Empty(t, fitReason(tsk, n))
+
+		n.Allocate(0, 0, 70<<30)
+		assert.NotEmpty(t, fitReason(tsk, n))
+
+		n.Release(0, 0, 70<<30)
+		n.Allocate(2.5, 0, 0)
+		assert.NotEmpty(t, fitReason(tsk, n))
+
+		n.Release(2.5, 0, 0)
+		assert.Empty(t, fitReason(tsk, n))
+	})
+
+	t.Run("nodes without stats are kept", func(t *testing.T) {
+		assert.Empty(t, fitReason(task.Task{Cpu: 64, Memory: 1 << 40, Disk: 1 << 50}, &node.Node{Name: "unknown"}))
+	})
+}
+
+func TestEpvm_Score(t *testing.T) {
+	idle := newResourceNode("idle", 7*1024*1024, 900<<30, 0.1, 0)
+	busy := newResourceNode("busy", 1*1024*1024, 100<<30, 4, 3)
+
+	e := &Epvm{}
+	tsk := task.Task{Size: 20 << 30, Memory: 512 << 20}
+
+	scores := e.Score(context.Background(), tsk, []*node.Node{busy, idle})
+
+	// scores are negated costs
+	assert.Less(t, scores["idle"], 0.0)
+	assert.Greater(t, scores["idle"], scores["busy"])
+
+	got := e.PickN(scores, []*node.Node{busy, idle}, 1)
+	assert.Equal(t, "idle", got[0].Name)
+
+	t.Run("marginal cost uses LIEB", func(t *testing.T) {
+		n := &node.Node{Name: "bare"}
+
+		// only the load term applies without stats: 0 -> cpu/(1+cpu)
+		costs := marginalCosts(task.Task{Cpu: 1}, n)
+		assert.Len(t, costs, 1)
+		assert.Equal(t, "load_cost", costs[0].Name)
+		assert.InDelta(t, math.Pow(LIEB, 0.5)-1, costs[0].Value, 0.0001)
+	})
+
+	t.Run("cost grows with usage", func(t *testing.T) {
+		assert.Greater(t, resourceCost(0.8, 0.9), resourceCost(0.1, 0.2))
+	})
+}
+
+func newStorageNode(name string, paths ...stats.StorageStats) *node.Node {
+	return &node.Node{
+		Name:   name,
+		Status: node.StatusReady,
+		Stats: stats.Stats{
+			ClientStats: map[string]stats.ClientStats{
+				"qbit": {MaxActiveDownloadsAllowed: 3, Status: stats.ClientStatusReady, Storage: paths},
+			},
+		},
+	}
+}
+
+func Test_storageHeadroom(t *testing.T) {
+	const gb = 1 << 30
+
+	tests := []struct {
+		name     string
+		paths    []stats.StorageStats
+		size     int64
+		headroom int64
+		fits     bool
+		ok       bool
+	}{
+		{
+			name: "no rules",
+			size: 10 * gb,
+			fits: true,
+		},
+		{
+			name:     "above min free",
+			paths:    []stats.StorageStats{{Path: "/a", MinFree: 50 * gb, Total: 1000 * gb, Used: 800 * gb, Free: 200 * gb}},
+			size:     100 * gb,
+			headroom: 50 * gb,
+			fits:     true,
+			ok:       true,
+		},
+		{
+			name:  "below min free",
+			paths: []stats.StorageStats{{Path: "/a", MinFree: 50 * gb, Total: 1000 * gb, Used: 800 * gb, Free: 200 * gb}},
+			size:  160 * gb,
+			fits:  false,
+			ok:    true,
+		},
+		{
+			name:  "above max usage",
+			paths: []stats.StorageStats{{Path: "/a", MaxUsage: 850 * gb, Total: 1000 * gb, Used: 800 * gb, Free: 200 * gb}},
+			size:  60 * gb,
+			fits:  false,
+			ok:    true,
+		},
+		{
+			name: "best path wins",
+			paths: []stats.StorageStats{
+				{Path: "/a", MinFree: 50 * gb, Total: 1000 * gb, Used: 950 * gb, Free: 50 * gb},
+				{Path: "/b", MinFree: 50 * gb, MaxUsage: 500 * gb, Total: 2000 * gb, Used: 300 * gb, Free: 1700 * gb},
+			},
+			size:     100 * gb,
+			headroom: 100 * gb,
+			fits:     true,
+			ok:       true,
+		},
+		{
+			name:  "unreadable path",
+			paths: []stats.StorageStats{{Path: "/a", MinFree: 50 * gb}},
+			size:  1 * gb,
+			fits:  false,
+			ok:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headroom, fits, ok := storageHeadroom(newStorageNode("node", tt.paths...), tt.size)
+			assert.Equal(t, tt.headroom, headroom)
+			assert.Equal(t, tt.fits, fits)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+
+	t.Run("tightest client wins", func(t *testing.T) {
+		n := newStorageNode("node", stats.StorageStats{Path: "/a", Total: 1000 * gb, Used: 0, Free: 1000 * gb})
+		n.Stats.ClientStats["qbit2"] = stats.ClientStats{Storage: []stats.StorageStats{{Path: "/b", Total: 100 * gb, Free: 100 * gb}}}
+
+		headroom, fits, _ := storageHeadroom(n, 10*gb)
+		assert.True(t, fits)
+		assert.Equal(t, int64(90*gb), headroom)
+
+		_, fits, _ = storageHeadroom(n, 200*gb)
+		assert.False(t, fits)
+	})
+
+	t.Run("allocated disk counts as used", func(t *testing.T) {
+		n := newStorageNode("node", stats.StorageStats{Path: "/a", Total: 100 * gb, Free: 100 * gb})
+		n.Allocate(0, 0, 95*gb)
+
+		_, fits, _ := storageHeadroom(n, 10*gb)
+		assert.False(t, fits)
+	})
+}
+
+func TestLeastActive_Score_headroom(t *testing.T) {
+	const gb = 1 << 30
+
+	roomy := newStorageNode("roomy", stats.StorageStats{Path: "/a", Total: 2000 * gb, Free: 1500 * gb})
+	tight := newStorageNode("tight", stats.StorageStats{Path: "/a", MinFree: 50 * gb, Total: 500 * gb, Free: 180 * gb})
+
+	r := &LeastActive{}
+	scores := r.Score(context.Background(), task.Task{Size: 100 * gb}, []*node.Node{tight, roomy})
+
+	assert.Greater(t, scores["roomy"], scores["tight"])
+	assert.InDelta(t, 120+maxHeadroomRatio, scores["roomy"], 0.0001)
+	assert.InDelta(t, 120+0.3, scores["tight"], 0.0001)
+}
+
+func TestSpread(t *testing.T) {
+	newRanked := func() []*node.Node {
+		return []*node.Node{
+			{Name: "eu1", Labels: map[string]string{"region": "eu"}},
+			{Name: "eu2", Labels: map[string]string{"region": "eu"}},
+			{Name: "us1", Labels: map[string]string{"region": "us"}},
+			{Name: "none", Labels: map[string]string{}},
+		}
+	}
+
+	names := func(nodes []*node.Node) []string {
+		var out []string
+		for _, n := range nodes {
+			out = append(out, n.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name       string
+		number     int
+		strict     bool
+		wantPicked []string
+		wantSpares []string
+	}{
+		{name: "distinct values first", number: 2, wantPicked: []string{"eu1", "us1"}, wantSpares: []string{"eu2", "none"}},
+		{name: "missing label is its own value", number: 3, wantPicked: []string{"eu1", "us1", "none"}, wantSpares: []string{"eu2"}},
+		{name: "fills up when not strict", number: 4, wantPicked: []string{"eu1", "us1", "none", "eu2"}},
+		{name: "strict under-replicates", number: 4, strict: true, wantPicked: []string{"eu1", "us1", "none"}, wantSpares: []string{"eu2"}},
+		{name: "strict spares keep used values", number: 1, strict: true, wantPicked: []string{"eu1"}, wantSpares: []string{"eu2", "us1", "none"}},
+		{name: "zero defaults to one", number: 0, wantPicked: []string{"eu1"}, wantSpares: []string{"eu2", "us1", "none"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			picked, spares := Spread(context.Background(), newRanked(), "region", tt.number, tt.strict)
+			assert.Equal(t, tt.wantPicked, names(picked))
+			assert.Equal(t, tt.wantSpares, names(spares))
+		})
+	}
+}
+
+func TestParseRequirement(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Requirement
+		wantErr bool
+	}{
+		{expr: "region in (eu, us)", want: Requirement{Key: "region", Operator: OperatorIn, Values: []string{"eu", "us"}}},
+		{expr: "region in eu,us", want: Requirement{Key: "region", Operator: OperatorIn, Values: []string{"eu", "us"}}},
+		{expr: "region IN (eu)", want: Requirement{Key: "region", Operator: OperatorIn, Values: []string{"eu"}}},
+		{expr: "disktype notin (hdd)", want: Requirement{Key: "disktype", Operator: OperatorNotIn, Values: []string{"hdd"}}},
+		{expr: "gpu exists", want: Requirement{Key: "gpu", Operator: OperatorExists}},
+		{expr: "gpu", want: Requirement{Key: "gpu", Operator: OperatorExists}},
+		{expr: "archive !exists", want: Requirement{Key: "archive", Operator: OperatorDoesNotExist}},
+		{expr: "!archive", want: Requirement{Key: "archive", Operator: OperatorDoesNotExist}},
+		{expr: "network gt 1G", want: Requirement{Key: "network", Operator: OperatorGt, Values: []string{"1G"}}},
+		{expr: "  cores   lt  16 ", want: Requirement{Key: "cores", Operator: OperatorLt, Values: []string{"16"}}},
+		{expr: "", wantErr: true},
+		{expr: "!", wantErr: true},
+		{expr: "region in", wantErr: true},
+		{expr: "region in ()", wantErr: true},
+		{expr: "region in (,)", wantErr: true},
+		{expr: "gpu exists yes", wantErr: true},
+		{expr: "network gt fast", wantErr: true},
+		{expr: "network lt", wantErr: true},
+		{expr: "region = eu", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseRequirement(tt.expr)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSelector)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	got, err := ParseSelector([]string{"region in (eu)", "network gt 1G"})
+	assert.NoError(t, err)
+	assert.Len(t, got, 2)
+
+	got, err = ParseSelector(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	_, err = ParseSelector([]string{"region in (eu)", "bad op x"})
+	assert.ErrorIs(t, err, ErrInvalidSelector)
+}
+
+func TestRequirement_Matches(t *testing.T) {
+	nodeLabels := map[string]string{
+		"region":   "eu",
+		"disktype": "ssd",
+		"network":  "10G",
+		"cores":    "8",
+		"name":     "fast",
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "region in (eu, us)", want: true},
+		{expr: "region in (us)", want: false},
+		{expr: "missing in (eu)", want: false},
+		{expr: "disktype notin (hdd)", want: true},
+		{expr: "disktype notin (ssd, nvme)", want: false},
+		{expr: "missing notin (hdd)", want: true},
+		{expr: "region exists", want: true},
+		{expr: "missing exists", want: false},
+		{expr: "missing !exists", want: true},
+		{expr: "region !exists", want: false},
+		{expr: "network gt 1G", want: true},
+		{expr: "network gt 10G", want: false},
+		{expr: "network lt 25G", want: true},
+		{expr: "network lt 1G", want: false},
+		{expr: "cores gt 4", want: true},
+		{expr: "cores lt 4", want: false},
+		{expr: "missing gt 1", want: false},
+		{expr: "name gt 1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			req, err := ParseRequirement(tt.expr)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, req.Matches(nodeLabels))
+		})
+	}
+
+	t.Run("unknown operator never matches", func(t *testing.T) {
+		assert.False(t, Requirement{Key: "region", Operator: "eq", Values: []string{"eu"}}.Matches(nodeLabels))
+	})
+
+	t.Run("invalid number never matches", func(t *testing.T) {
+		assert.False(t, Requirement{Key: "cores", Operator: OperatorGt, Values: []string{"many"}}.Matches(nodeLabels))
+	})
+}
+
+func TestRequirement_String(t *testing.T) {
+	for _, expr := range []string{"region in (eu, us)", "disktype notin (hdd)", "gpu exists", "gpu !exists", "network gt 1G"} {
+		req, err := ParseRequirement(expr)
+		assert.NoError(t, err)
+		assert.Equal(t, expr, req.String())
+	}
+}
+
+func Test_checkNode_selector(t *testing.T) {
+	n := &node.Node{Name: "a", Status: node.StatusReady, Labels: map[string]string{"region": "eu", "network": "10G"}}
+
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		selectors []string
+		want      string
+	}{
+		{name: "no selectors", want: ""},
+		{name: "all match", selectors: []string{"region in (eu)", "network gt 1G"}, want: ""},
+		{name: "one fails", selectors: []string{"region in (eu)", "network gt 40G"}, want: `selector "network gt 40G" doesn't match`},
+		{name: "labels map still applies", labels: map[string]string{"region": "us"}, selectors: []string{"network gt 1G"}, want: "label region=us doesn't match region=eu"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, err := ParseSelector(tt.selectors)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, checkNode(context.Background(), task.Task{Labels: tt.labels}, n, reqs))
+		})
+	}
+}
+
+func Test_parseQuantity(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "100", want: 100},
+		{in: "2.5", want: 2.5},
+		{in: "-1", want: -1},
+		{in: "1G", want: 1e9},
+		{in: "500M", want: 5e8},
+		{in: "1GiB", want: 1 << 30},
+		{in: "fast", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseQuantity(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTaints(t *testing.T) {
+	archive := &node.Node{
+		Name:   "archive",
+		Taints: []task.Taint{{Key: "disktype", Value: "hdd", Effect: task.TaintEffectNoSchedule}},
+	}
+	shared := &node.Node{
+		Name:         "shared",
+		ServerTaints: []task.Taint{{Key: "shared", Effect: task.TaintEffectPreferNoSchedule}},
+	}
+	plain := &node.Node{Name: "plain"}
+
+	tests := []struct {
+		name        string
+		tolerations []task.Toleration
+		node        *node.Node
+		tolerated   bool
+		penalty     float64
+	}{
+		{name: "untainted node", node: plain, tolerated: true},
+		{name: "no schedule without toleration", node: archive, tolerated: false},
+		{name: "no schedule with equal toleration", node: archive, tolerations: []task.Toleration{{Key: "disktype", Value: "hdd"}}, tolerated: true},
+		{name: "no schedule with other value", node: archive, tolerations: []task.Toleration{{Key: "disktype", Value: "ssd"}}, tolerated: false},
+		{name: "no schedule with exists toleration", node: archive, tolerations: []task.Toleration{{Key: "disktype", Operator: task.TolerationOpExists}}, tolerated: true},
+		{name: "no schedule with other effect", node: archive, tolerations: []task.Toleration{{Key: "disktype", Value: "hdd", Effect: task.TaintEffectPreferNoSchedule}}, tolerated: false},
+		{name: "prefer no schedule is penalized", node: shared, tolerated: true, penalty: preferNoSchedulePenalty},
+		{name: "prefer no schedule tolerated", node: shared, tolerations: []task.Toleration{{Key: "shared", Effect: task.TaintEffectPreferNoSchedule}}, tolerated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsk := task.Task{Tolerations: tt.tolerations}
+			_, untolerated := untoleratedTaint(tsk, tt.node)
+			assert.Equal(t, tt.tolerated, !untolerated)
+			assert.Equal(t, tt.penalty, taintPenalty(tsk, tt.node))
+		})
+	}
+
+	t.Run("penalty lowers the score", func(t *testing.T) {
+		r := &LeastActive{}
+		scores := r.Score(context.Background(), task.Task{}, []*node.Node{shared, plain})
+		assert.Equal(t, scores["plain"]-preferNoSchedulePenalty, scores["shared"])
+	})
+}
+
+func TestToleration_Validate(t *testing.T) {
+	assert.NoError(t, task.Toleration{Key: "disktype"}.Validate())
+	assert.NoError(t, task.Toleration{Key: "disktype", Operator: task.TolerationOpExists, Effect: task.TaintEffectNoSchedule}.Validate())
+	assert.Error(t, task.Toleration{Key: "disktype", Operator: "exist"}.Validate())
+	assert.Error(t, task.Toleration{Key: "disktype", Effect: "NoExecute"}.Validate())
+}
+
+func Test_preferenceScore(t *testing.T) {
+	nvme := &node.Node{Name: "nvme", Labels: map[string]string{"disktype": "nvme", "region": "eu"}}
+	hdd := &node.Node{Name: "hdd", Labels: map[string]string{"disktype": "hdd"}}
+
+	preferred := []task.PreferredLabel{
+		{Key: "disktype", Value: "nvme", Weight: 30},
+		{Key: "region", Weight: 10},
+		{Key: "disktype", Value: "hdd", Weight: -5},
+	}
+
+	tests := []struct {
+		name      string
+		node      *node.Node
+		preferred []task.PreferredLabel
+		want      float64
+	}{
+		{name: "no preferences", node: nvme, want: 0},
+		{name: "value and exists match", node: nvme, preferred: preferred, want: 40},
+		{name: "negative weight", node: hdd, preferred: preferred, want: -5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, preferenceScore(context.Background(), tt.preferred, tt.node))
+		})
+	}
+
+	t.Run("preferred nodes score higher but others stay candidates", func(t *testing.T) {
+		r := &LeastActive{}
+		tsk := task.Task{PreferredLabels: preferred[:1]}
+
+		scores := r.Score(context.Background(), tsk, []*node.Node{hdd, nvme})
+		assert.Equal(t, scores["hdd"]+30, scores["nvme"])
+		assert.Len(t, scores, 2)
+	})
+}
+
+// fullNode is a node in region eu with every download slot of its client taken
+func fullNode() *node.Node {
+	n := newResourceNode("a", 1024*1024, 10<<30, 0, 4)
+	n.Labels = map[string]string{"region": "eu"}
+	n.Stats.ClientStats["qbit"] = stats.ClientStats{ActiveDownloadsCount: 4, MaxActiveDownloadsAllowed: 4, Status: stats.ClientStatusNotReady}
+
+	return n
+}
+
+func Test_checkNode(t *testing.T) {
+	selector, err := ParseSelector([]string{"region in (eu)"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		task task.Task
+		node *node.Node
+		want string
+	}{
+		{
+			name: "not ready",
+			node: &node.Node{Name: "a", Status: node.StatusUnknown},
+			want: "node status is UNKNOWN",
+		},
+		{
+			name: "missing label",
+			task: task.Task{Labels: map[string]string{"disktype": "nvme"}},
+			node: &node.Node{Name: "a", Status: node.StatusReady, Labels: map[string]string{"region": "eu"}},
+			want: "label disktype=nvme is missing",
+		},
+		{
+			name: "label mismatch",
+			task: task.Task{Labels: map[string]string{"region": "eu"}},
+			node: &node.Node{Name: "a", Status: node.StatusReady, Labels: map[string]string{"region": "us"}},
+			want: "label region=eu doesn't match region=us",
+		},
+		{
+			name: "selector",
+			node: &node.Node{Name: "a", Status: node.StatusReady, Labels: map[string]string{"region": "us"}},
+			want: `selector "region in (eu)" doesn't match`,
+		},
+		{
+			name: "taint",
+			node: &node.Node{Name: "a", Status: node.StatusReady, Labels: map[string]string{"region": "eu"}, Taints: []task.Taint{{Key: "disktype", Value: "hdd", Effect: task.TaintEffectNoSchedule}}},
+			want: "taint disktype=hdd:NoSchedule is not tolerated",
+		},
+		{
+			name: "client full",
+			node: fullNode(),
+			want: "client qbit is not ready: NOT_READY, 4/4 active downloads",
+		},
+		{
+			name: "force add on full client",
+			task: task.Task{ForceAdd: true},
+			node: fullNode(),
+			want: "",
+		},
+		{
+			name: "force add still checks requests",
+			task: task.Task{ForceAdd: true, Disk: 20 << 30},
+			node: fullNode(),
+			want: "not enough disk: 20 GiB requested, 10 GiB free",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, checkNode(context.Background(), tt.task, tt.node, selector))
+		})
+	}
+}
+
+func Test_fitReason(t *testing.T) {
+	n := newResourceNode("a", 1024*1024, 10<<30, 0, 0)
+
+	assert.Equal(t, "", fitReason(task.Task{Memory: 512 << 20, Disk: 1 << 30}, n))
+	assert.Equal(t, "not enough memory: 2.0 GiB requested, 1.0 GiB free", fitReason(task.Task{Memory: 2 << 30}, n))
+	assert.Equal(t, "not enough disk: 20 GiB requested, 10 GiB free", fitReason(task.Task{Disk: 20 << 30}, n))
+
+	n.Allocate(0, 0, 15<<30)
+	assert.Equal(t, "not enough disk: 1.0 GiB requested, -5.0 GiB free", fitReason(task.Task{Disk: 1 << 30}, n))
+
+	t.Run("storage paths win over root", func(t *testing.T) {
+		const gb = 1 << 30
+
+		// small root filesystem, large download mount
+		n := newResourceNode("seedbox", 4*1024*1024, 10*gb, 0, 0)
+		n.Stats.ClientStats["qbit"] = stats.ClientStats{
+			MaxActiveDownloadsAllowed: 4,
+			Status:                    stats.ClientStatusReady,
+			Storage: []stats.StorageStats{
+				{Path: "/data", Total: 12000 * gb, Used: 2000 * gb, Free: 10000 * gb},
+				{Path: "/broken"},
+			},
+		}
+
+		assert.Equal(t, "", fitReason(task.Task{Size: 50 * gb}, n))
+		assert.Equal(t, "not enough disk: 11 TiB requested, 9.8 TiB free", fitReason(task.Task{Disk: 11000 * gb}, n))
+
+		usage, ok := diskUsage(n, 0)
+		assert.True(t, ok)
+		assert.InDelta(t, 2000.0/12000, usage, 0.0001)
+	})
+
+	t.Run("active downloads reserve their remaining bytes", func(t *testing.T) {
+		const gb = 1 << 30
+
+		n := newResourceNode("node", 4*1024*1024, 100*gb, 0, 1)
+		cs := n.Stats.ClientStats["qbit"]
+		cs.ActiveDownloads = []qbittorrent.Torrent{{Hash: "abc", Size: 80 * gb, Progress: 0.25}}
+		n.Stats.ClientStats["qbit"] = cs
+
+		assert.Equal(t, "", fitReason(task.Task{Disk: 40 * gb}, n))
+		assert.Equal(t, "not enough disk: 50 GiB requested, 40 GiB free", fitReason(task.Task{Disk: 50 * gb}, n))
+
+		n.Stats.ClientStats["qbit"] = stats.ClientStats{
+			MaxActiveDownloadsAllowed: 4,
+			ActiveDownloads:           cs.ActiveDownloads,
+			Storage:                   []stats.StorageStats{{Path: "/data", Total: 200 * gb, Used: 100 * gb, Free: 100 * gb}},
+		}
+
+		_, fits, _ := storageHeadroom(n, 50*gb)
+		assert.False(t, fits)
+	})
+}
+
+func TestExplain(t *testing.T) {
+	idle := newResourceNode("idle", 7*1024*1024, 900<<30, 0.1, 0)
+	busy := newResourceNode("busy", 1*1024*1024, 100<<30, 4, 3)
+	nodes := []*node.Node{idle, busy}
+
+	tsk := task.Task{Memory: 1 << 30, PreferredLabels: []task.PreferredLabel{{Key: "disktype", Weight: 5}}}
+	idle.Labels = map[string]string{}
+	busy.Labels = map[string]string{"disktype": "nvme"}
+
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			sc, err := New(name)
+			assert.NoError(t, err)
+
+			explainer, ok := sc.(Explainer)
+			assert.True(t, ok)
+
+			breakdowns := explainer.Explain(context.Background(), tsk, nodes)
+			scores := sc.Score(context.Background(), tsk, nodes)
+
+			for _, n := range nodes {
+				b := breakdowns[n.Name]
+				assert.Equal(t, scores[n.Name], b.Score)
+
+				sum := 0.0
+				for _, part := range b.Parts {
+					sum += part.Value
+				}
+				assert.InDelta(t, b.Score, sum, 0.0001)
+			}
+
+			assert.Contains(t, breakdowns["busy"].Parts, ScorePart{Name: "preferred_labels", Value: 5})
+		})
+	}
+
+	t.Run("round robin explain doesn't advance", func(t *testing.T) {
+		r := &RoundRobin{LastWorker: -1}
+
+		r.Explain(context.Background(), task.Task{}, nodes)
+		r.Explain(context.Background(), task.Task{}, nodes)
+		assert.Equal(t, -1, r.LastWorker)
+
+		scores := r.Score(context.Background(), task.Task{}, nodes)
+		assert.Equal(t, "idle", r.PickN(scores, slices.Clone(nodes), 1)[0].Name)
+		assert.Equal(t, 0, r.LastWorker)
+	})
+}
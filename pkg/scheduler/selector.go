@@ -0,0 +1,181 @@
+package scheduler
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+type Operator string
+
+const (
+	OperatorIn           Operator = "in"
+	OperatorNotIn        Operator = "notin"
+	OperatorExists       Operator = "exists"
+	OperatorDoesNotExist Operator = "!exists"
+	OperatorGt           Operator = "gt"
+	OperatorLt           Operator = "lt"
+)
+
+var ErrInvalidSelector = errors.New("invalid label selector")
+
+// Requirement is a single label selector expression, like "region in (eu, us)" or "network gt 1G".
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// ParseSelector parses label selector expressions. Supported forms:
+//
+//	key in (a, b)
+//	key notin (a, b)
+//	key exists, or just key
+//	key !exists, or !key
+//	key gt 1G
+//	key lt 100
+//
+// gt and lt compare numbers, with optional size suffixes like 500M or 10G.
+func ParseSelector(exprs []string) ([]Requirement, error) {
+	reqs := make([]Requirement, 0, len(exprs))
+
+	for _, expr := range exprs {
+		req, err := ParseRequirement(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	return reqs, nil
+}
+
+func ParseRequirement(expr string) (Requirement, error) {
+	fields := strings.Fields(expr)
+
+	switch len(fields) {
+	case 0:
+		return Requirement{}, errors.Wrap(ErrInvalidSelector, "empty expression")
+
+	case 1:
+		if key, ok := strings.CutPrefix(fields[0], "!"); ok {
+			return newRequirement(expr, key, OperatorDoesNotExist, nil)
+		}
+
+		return newRequirement(expr, fields[0], OperatorExists, nil)
+	}
+
+	key, op := fields[0], Operator(strings.ToLower(fields[1]))
+	rest := strings.TrimSpace(strings.Join(fields[2:], " "))
+
+	switch op {
+	case OperatorExists, OperatorDoesNotExist:
+		if rest != "" {
+			return Requirement{}, errors.Wrapf(ErrInvalidSelector, "%q: %s takes no values", expr, op)
+		}
+
+		return newRequirement(expr, key, op, nil)
+
+	case OperatorIn, OperatorNotIn:
+		rest = strings.TrimSuffix(strings.TrimPrefix(rest, "("), ")")
+
+		var values []string
+		for _, v := range strings.Split(rest, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+
+		if len(values) == 0 {
+			return Requirement{}, errors.Wrapf(ErrInvalidSelector, "%q: %s needs at least one value", expr, op)
+		}
+
+		return newRequirement(expr, key, op, values)
+
+	case OperatorGt, OperatorLt:
+		if _, err := parseQuantity(rest); err != nil {
+			return Requirement{}, errors.Wrapf(ErrInvalidSelector, "%q: %s needs a number", expr, op)
+		}
+
+		return newRequirement(expr, key, op, []string{rest})
+	}
+
+	return Requirement{}, errors.Wrapf(ErrInvalidSelector, "%q: unknown operator %q", expr, fields[1])
+}
+
+func newRequirement(expr string, key string, op Operator, values []string) (Requirement, error) {
+	if key == "" {
+		return Requirement{}, errors.Wrapf(ErrInvalidSelector, "%q: empty key", expr)
+	}
+
+	return Requirement{Key: key, Operator: op, Values: values}, nil
+}
+
+// Matches checks the requirement against node labels.
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+
+	switch r.Operator {
+	case OperatorExists:
+		return ok
+	case OperatorDoesNotExist:
+		return !ok
+	case OperatorIn:
+		return ok && slices.Contains(r.Values, value)
+	case OperatorNotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	case OperatorGt, OperatorLt:
+		if !ok {
+			return false
+		}
+
+		have, err := parseQuantity(value)
+		if err != nil {
+			return false
+		}
+
+		want, err := parseQuantity(r.Values[0])
+		if err != nil {
+			return false
+		}
+
+		if r.Operator == OperatorGt {
+			return have > want
+		}
+
+		return have < want
+	}
+
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case OperatorExists, OperatorDoesNotExist:
+		return r.Key + " " + string(r.Operator)
+	case OperatorIn, OperatorNotIn:
+		return r.Key + " " + string(r.Operator) + " (" + strings.Join(r.Values, ", ") + ")"
+	}
+
+	return r.Key + " " + string(r.Operator) + " " + strings.Join(r.Values, "")
+}
+
+// parseQuantity parses plain numbers, or sizes like 1G, 500M or 1GiB
+func parseQuantity(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+
+	b, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(b), nil
+}
@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"context"
+	"slices"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PickNodes picks the replicas of the task from the scored candidates, spread over a label when the task asks for it,
+// and returns the remaining candidates best first.
+func PickNodes(ctx context.Context, sc Scheduler, t task.Task, scores map[string]float64, candidates []*node.Node) (picked []*node.Node, spares []*node.Node) {
+	// everything ranked, best first
+	ranked := sc.PickN(scores, slices.Clone(candidates), len(candidates))
+
+	if t.SpreadBy != "" {
+		return Spread(ctx, ranked, t.SpreadBy, t.MaxAllowedReplicas, t.SpreadStrict)
+	}
+
+	picked = sc.PickN(scores, slices.Clone(candidates), t.MaxAllowedReplicas)
+
+	spares = slices.DeleteFunc(ranked, func(n *node.Node) bool {
+		return slices.Contains(picked, n)
+	})
+
+	return picked, spares
+}
+
+// Spread picks number nodes from ranked, best first, so that replicas land on distinct values of the label key.
+// Nodes without the label share a single value. When there are not enough distinct values the best remaining
+// nodes fill the gap, unless strict is set, then fewer nodes are picked instead.
+// spares are the nodes not picked, best first. They include the values already picked, so a failed replica can be
+// replaced on its own value. In strict mode callers have to skip spares on values of replicas that are still live.
+func Spread(ctx context.Context, ranked []*node.Node, key string, number int, strict bool) (picked []*node.Node, spares []*node.Node) {
+	if number <= 0 {
+		number = 1
+	}
+
+	used := map[string]bool{}
+	var rest []*node.Node
+
+	for _, n := range ranked {
+		value := LabelValue(ctx, n, key)
+
+		if len(picked) < number && !used[value] {
+			used[value] = true
+			picked = append(picked, n)
+			continue
+		}
+
+		rest = append(rest, n)
+	}
+
+	if strict {
+		return picked, rest
+	}
+
+	// fill up with co-located nodes
+	missing := min(number-len(picked), len(rest))
+	picked = append(picked, rest[:missing]...)
+
+	return picked, rest[missing:]
+}
+
+// LabelValue returns the value of the label key on the node, or "" when the node doesn't have it.
+func LabelValue(ctx context.Context, n *node.Node, key string) string {
+	labels, err := n.GetLabels(ctx)
+	if err != nil {
+		log.Error().Err(err).Msgf("could not get labels for node %s", n.Name)
+		return ""
+	}
+
+	return labels[key]
+}
@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+)
+
+// preferNoSchedulePenalty is subtracted from the score for every PreferNoSchedule taint the task doesn't tolerate
+const preferNoSchedulePenalty = 50.0
+
+// untoleratedTaint returns the first NoSchedule taint of the node the task doesn't tolerate
+func untoleratedTaint(t task.Task, n *node.Node) (task.Taint, bool) {
+	for _, taint := range n.AllTaints() {
+		if taint.Effect == task.TaintEffectNoSchedule && !t.Tolerates(taint) {
+			return taint, true
+		}
+	}
+
+	return task.Taint{}, false
+}
+
+// taintPenalty returns the score penalty for PreferNoSchedule taints the task doesn't tolerate
+func taintPenalty(t task.Task, n *node.Node) float64 {
+	penalty := 0.0
+	for _, taint := range n.AllTaints() {
+		if taint.Effect == task.TaintEffectPreferNoSchedule && !t.Tolerates(taint) {
+			penalty += preferNoSchedulePenalty
+		}
+	}
+
+	return penalty
+}
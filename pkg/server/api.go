@@ -5,14 +5,19 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	mw "github.com/autobrr/distribrr/pkg/middleware"
+	"github.com/autobrr/distribrr/pkg/scheduler"
 	"github.com/autobrr/distribrr/pkg/task"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
-	"github.com/rs/zerolog"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
@@ -134,11 +139,8 @@ func (s *APIServer) Handler() http.Handler {
 
 			r.Route("/tasks", func(r chi.Router) {
 				r.Post("/", func(w http.ResponseWriter, r *http.Request) {
-					te := task.NewEvent()
-
-					if err := json.NewDecoder(r.Body).Decode(&te.Task); err != nil {
-						render.Status(r, http.StatusBadRequest)
-						render.JSON(w, r, map[string]string{"error": "could not decode request body"})
+					te, ok := decodeTask(w, r)
+					if !ok {
 						return
 					}
 
@@ -147,25 +149,157 @@ func (s *APIServer) Handler() http.Handler {
 					ctx := context.WithoutCancel(r.Context())
 
 					if err := s.service.AddTask(ctx, te); err != nil {
+						var dupErr *DuplicateTaskError
+						if errors.As(err, &dupErr) {
+							render.Status(r, http.StatusConflict)
+							render.JSON(w, r, map[string]any{"error": err.Error(), "original_id": dupErr.OriginalID, "merged": dupErr.Merged})
+							return
+						}
+
+						if errors.Is(err, ErrTaskParked) {
+							render.Status(r, http.StatusAccepted)
+							render.JSON(w, r, map[string]string{"id": te.Task.ID.String(), "status": err.Error()})
+							return
+						}
+
+						if errors.Is(err, ErrTaskCancelled) || errors.Is(err, ErrTaskExists) {
+							render.Status(r, http.StatusConflict)
+							render.JSON(w, r, map[string]string{"error": err.Error()})
+							return
+						}
+
+						if errors.Is(err, ErrIndexerLimit) {
+							render.Status(r, http.StatusTooManyRequests)
+							render.JSON(w, r, map[string]string{"error": err.Error()})
+							return
+						}
+
+						if errors.Is(err, ErrQueueFull) {
+							render.Status(r, http.StatusServiceUnavailable)
+							render.JSON(w, r, map[string]string{"error": err.Error()})
+							return
+						}
+
 						render.Status(r, http.StatusBadGateway)
 						render.JSON(w, r, map[string]string{"error": err.Error()})
 						return
 					}
 
 					render.Status(r, http.StatusCreated)
-					render.PlainText(w, r, "OK")
+					render.JSON(w, r, map[string]string{"id": te.Task.ID.String()})
 				})
 
 				r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-					if err := getTasksHandler(r.Context()); err != nil {
+					filter, err := parseTaskFilter(r)
+					if err != nil {
+						render.Status(r, http.StatusBadRequest)
+						render.JSON(w, r, map[string]string{"error": err.Error()})
+						return
+					}
+
+					list, err := s.service.ListTasks(r.Context(), filter)
+					if err != nil {
+						render.Status(r, http.StatusBadRequest)
+						render.JSON(w, r, map[string]string{"error": err.Error()})
+						return
+					}
+
+					render.Status(r, http.StatusOK)
+					render.JSON(w, r, list)
+				})
+
+				r.Get("/{taskID}", func(w http.ResponseWriter, r *http.Request) {
+					id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+					if err != nil {
+						render.Status(r, http.StatusBadRequest)
+						render.JSON(w, r, map[string]string{"error": "invalid task id"})
+						return
+					}
+
+					detail, err := s.service.GetTask(r.Context(), id)
+					if err != nil {
+						if errors.Is(err, ErrTaskNotFound) {
+							render.Status(r, http.StatusNotFound)
+							render.JSON(w, r, map[string]string{"error": err.Error()})
+							return
+						}
+
 						render.Status(r, http.StatusInternalServerError)
+						render.JSON(w, r, map[string]string{"error": err.Error()})
 						return
 					}
 
-					render.PlainText(w, r, "get tasks")
 					render.Status(r, http.StatusOK)
-					return
+					render.JSON(w, r, detail)
+				})
+
+				r.Delete("/{taskID}", func(w http.ResponseWriter, r *http.Request) {
+					id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+					if err != nil {
+						render.Status(r, http.StatusBadRequest)
+						render.JSON(w, r, map[string]string{"error": "invalid task id"})
+						return
+					}
+
+					deleteFiles, _ := strconv.ParseBool(r.URL.Query().Get("delete_files"))
+
+					result, err := s.service.CancelTask(r.Context(), id, deleteFiles)
+					if err != nil {
+						if errors.Is(err, ErrTaskNotFound) {
+							render.Status(r, http.StatusNotFound)
+							render.JSON(w, r, map[string]string{"error": err.Error()})
+							return
+						}
+
+						render.Status(r, http.StatusInternalServerError)
+						render.JSON(w, r, map[string]string{"error": err.Error()})
+						return
+					}
+
+					render.Status(r, http.StatusOK)
+					render.JSON(w, r, result)
+				})
+			})
+
+			r.Route("/queue", func(r chi.Router) {
+				r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+					render.Status(r, http.StatusOK)
+					render.JSON(w, r, s.service.GetQueueStats())
 				})
+
+				r.Get("/{taskID}", func(w http.ResponseWriter, r *http.Request) {
+					id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+					if err != nil {
+						render.Status(r, http.StatusBadRequest)
+						render.JSON(w, r, map[string]string{"error": "invalid task id"})
+						return
+					}
+
+					position := s.service.GetQueuePosition(id)
+					if position == 0 {
+						render.Status(r, http.StatusNotFound)
+						render.JSON(w, r, map[string]string{"error": "task is not queued"})
+						return
+					}
+
+					render.Status(r, http.StatusOK)
+					render.JSON(w, r, map[string]any{"task_id": id, "position": position})
+				})
+			})
+
+			r.Post("/schedule/explain", func(w http.ResponseWriter, r *http.Request) {
+				te, ok := decodeTask(w, r)
+				if !ok {
+					return
+				}
+
+				render.Status(r, http.StatusOK)
+				render.JSON(w, r, s.service.ExplainTask(r.Context(), te.Task))
+			})
+
+			r.Get("/indexers", func(w http.ResponseWriter, r *http.Request) {
+				render.Status(r, http.StatusOK)
+				render.JSON(w, r, s.service.GetIndexerUsage())
 			})
 		})
 	})
@@ -173,9 +307,100 @@ func (s *APIServer) Handler() http.Handler {
 	return r
 }
 
-func getTasksHandler(ctx context.Context) error {
-	l := zerolog.Ctx(ctx)
-	l.Info().Msg("get tasks")
+// decodeTask reads and validates a task from the request body. It writes the error response when it fails.
+func decodeTask(w http.ResponseWriter, r *http.Request) (task.Event, bool) {
+	te := task.NewEvent()
+	te.Task = task.NewTask()
+
+	if err := json.NewDecoder(r.Body).Decode(&te.Task); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, map[string]string{"error": "could not decode request body"})
+		return te, false
+	}
+
+	// state is owned by the server
+	te.Task.State = task.Pending
+	if te.Task.ID == uuid.Nil {
+		te.Task.ID = uuid.New()
+	}
+	te.TaskID = te.Task.ID
+
+	priority, err := task.ParsePriority(string(te.Task.Priority))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+		return te, false
+	}
+	te.Task.Priority = priority
+
+	for _, toleration := range te.Task.Tolerations {
+		if err := toleration.Validate(); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, map[string]string{"error": err.Error()})
+			return te, false
+		}
+	}
+
+	if _, err := scheduler.ParseSelector(te.Task.Selectors); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+		return te, false
+	}
+
+	if te.Task.SchedulerType != "" && !scheduler.Exists(te.Task.SchedulerType) {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, map[string]any{"error": "unknown scheduler_type", "schedulers": scheduler.Names()})
+		return te, false
+	}
+
+	return te, true
+}
+
+// parseTaskFilter reads task list filters from query params like
+// ?state=running,failed&indexer=x&node=y&name=z&since=RFC3339&until=RFC3339&limit=50&cursor=abc
+func parseTaskFilter(r *http.Request) (TaskFilter, error) {
+	q := r.URL.Query()
+
+	f := TaskFilter{
+		Indexer: q.Get("indexer"),
+		Node:    q.Get("node"),
+		Name:    q.Get("name"),
+		Cursor:  q.Get("cursor"),
+	}
+
+	if states := q.Get("state"); states != "" {
+		for _, name := range strings.Split(states, ",") {
+			state, err := task.ParseState(strings.TrimSpace(name))
+			if err != nil {
+				return f, err
+			}
+			f.States = append(f.States, state)
+		}
+	}
+
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return f, errors.Wrap(err, "invalid since")
+		}
+		f.Since = t
+	}
+
+	if until := q.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return f, errors.Wrap(err, "invalid until")
+		}
+		f.Until = t
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return f, errors.Wrap(err, "invalid limit")
+		}
+		f.Limit = n
+	}
 
-	return nil
+	return f, nil
 }
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/autobrr/distribrr/pkg/task"
 	"github.com/autobrr/distribrr/pkg/version"
 
 	"github.com/rs/xid"
@@ -37,6 +38,7 @@ type JoinRequest struct {
 	NodeName   string            `json:"node_name"`
 	ClientAddr string            `json:"client_addr"`
 	Labels     map[string]string `yaml:"labels"`
+	Taints     []task.Taint      `json:"taints,omitempty"`
 	Token      string            `json:"token"`
 }
 
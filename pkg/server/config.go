@@ -2,13 +2,18 @@ package server
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/scheduler"
+	"github.com/autobrr/distribrr/pkg/task"
 
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	yml "gopkg.in/yaml.v3"
 )
 
 var k = koanf.New(".")
@@ -20,8 +25,20 @@ type AgentNode struct {
 }
 
 type Config struct {
-	Http  Http         `yaml:"http"`
-	Nodes []*AgentNode `yaml:"nodes"`
+	Http       Http         `yaml:"http"`
+	Database   Database     `yaml:"database"`
+	Queue      Queue        `yaml:"queue"`
+	Tasks      Tasks        `yaml:"tasks"`
+	Scheduler  Scheduler    `yaml:"scheduler"`
+	Duplicates Duplicates   `yaml:"duplicates"`
+	Nodes      []*AgentNode `yaml:"nodes"`
+	// Taints are added to the taints the agents report, by node name
+	Taints map[string][]task.Taint `yaml:"taints"`
+	// Routing rules apply labels, replicas, scheduler, priority and tags by indexer, category or name.
+	// The first matching rule wins
+	Routing []RoutingRule `yaml:"routing"`
+	// Indexers limits downloading and new tasks per indexer across all nodes, by indexer name
+	Indexers map[string]IndexerLimit `yaml:"indexers"`
 
 	configFile string `yaml:"-"`
 }
@@ -32,6 +49,55 @@ type Http struct {
 	Token string `yaml:"token"`
 }
 
+type Database struct {
+	// Path to the database file. Defaults to distribrr.db next to the config file
+	Path string `yaml:"path"`
+}
+
+type Tasks struct {
+	// Retention is how long completed and failed tasks are kept with their history. 0 keeps them forever
+	Retention time.Duration `yaml:"retention"`
+}
+
+type Scheduler struct {
+	// Default is the scheduler used when a task has no scheduler_type
+	Default string `yaml:"default"`
+	// RescheduleAttempts is how many times failed replicas are retried on other candidates. 0 disables rescheduling
+	RescheduleAttempts int `yaml:"rescheduleAttempts"`
+	// MaxWait is how long a task waits for a ready node before failing. 0 fails right away.
+	// Tasks can override it with max_wait
+	MaxWait time.Duration `yaml:"maxWait"`
+}
+
+type Duplicates struct {
+	// Window is how long the infohash of a task is remembered. 0 disables duplicate detection
+	Window time.Duration `yaml:"window"`
+	// Mode is reject to only refuse duplicates, or merge to also note them on the original task
+	Mode string `yaml:"mode"`
+}
+
+type Queue struct {
+	// Workers is the number of tasks dispatched to agents concurrently
+	Workers int `yaml:"workers"`
+	// Size is the max number of tasks waiting for a worker. 0 means unbounded
+	Size int `yaml:"size"`
+	// MaxInFlight caps concurrent dispatches per task priority, like backfill: 1. 0 or missing is unlimited
+	MaxInFlight map[string]int `yaml:"maxInFlight"`
+}
+
+func (q Queue) priorityLimits() map[task.Priority]int {
+	limits := map[task.Priority]int{}
+	for name, limit := range q.MaxInFlight {
+		priority, err := task.ParsePriority(name)
+		if err != nil {
+			log.Warn().Err(err).Msg("ignoring queue.maxInFlight entry")
+			continue
+		}
+		limits[priority] = limit
+	}
+	return limits
+}
+
 func NewConfig() *Config {
 	c := &Config{}
 	c.Defaults()
@@ -45,7 +111,24 @@ func (c *Config) Defaults() {
 		Port:  "7422",
 		Token: "",
 	}
+	c.Queue = Queue{
+		Workers: 4,
+		Size:    100,
+	}
+	c.Tasks = Tasks{
+		Retention: 7 * 24 * time.Hour,
+	}
+	c.Scheduler = Scheduler{
+		Default:            scheduler.DefaultScheduler,
+		RescheduleAttempts: 2,
+	}
+	c.Duplicates = Duplicates{
+		Window: 15 * time.Minute,
+		Mode:   DuplicateModeReject,
+	}
 	c.Nodes = make([]*AgentNode, 0)
+	c.Taints = map[string][]task.Taint{}
+	c.Indexers = map[string]IndexerLimit{}
 }
 
 func (c *Config) LoadFromFile(configPath string) error {
@@ -71,20 +154,60 @@ func (c *Config) LoadFromFile(configPath string) error {
 			log.Fatal().Err(err).Str("service", "config").Msgf("failed unmarshalling %q", configPath)
 		}
 	}
+
+	return c.validate()
+}
+
+// validate checks the parts of the config the server can't run without, so mistakes fail startup
+// instead of quietly turning features off.
+func (c *Config) validate() error {
+	if err := c.Duplicates.validate(); err != nil {
+		return errors.Wrap(err, "invalid duplicates config")
+	}
+
+	if c.Tasks.Retention < 0 {
+		return errors.New("invalid tasks config: retention can't be negative")
+	}
+
+	if _, err := newRouter(c.Routing); err != nil {
+		return errors.Wrap(err, "invalid routing rules")
+	}
+
+	if _, err := newIndexerLimiter(c.Indexers); err != nil {
+		return errors.Wrap(err, "invalid indexer limits")
+	}
+
 	return nil
 }
 
-func (c *Config) WriteToFile() error {
-	if c.configFile != "" {
-		// write file
-		data, err := yml.Marshal(&c)
-		if err != nil {
-			log.Fatal().Err(err).Msgf("failed parsing config: %q", c.configFile)
-		}
+// validate normalizes the mode and rejects unknown ones. An empty mode rejects duplicates.
+func (d *Duplicates) validate() error {
+	d.Mode = strings.ToLower(strings.TrimSpace(d.Mode))
 
-		if err := os.WriteFile(c.configFile, data, 0664); err != nil {
-			log.Fatal().Err(err).Msgf("could not write config to file: %q", c.configFile)
-		}
+	switch d.Mode {
+	case "":
+		d.Mode = DuplicateModeReject
+	case DuplicateModeReject, DuplicateModeMerge:
+	default:
+		return errors.Errorf("unknown mode %q, must be %s or %s", d.Mode, DuplicateModeReject, DuplicateModeMerge)
+	}
+
+	if d.Window < 0 {
+		return errors.Errorf("window can't be negative")
 	}
+
 	return nil
 }
+
+// DatabasePath returns the configured database path, or distribrr.db next to the config file.
+func (c *Config) DatabasePath() string {
+	if c.Database.Path != "" {
+		return c.Database.Path
+	}
+
+	if c.configFile != "" {
+		return filepath.Join(filepath.Dir(c.configFile), "distribrr.db")
+	}
+
+	return "distribrr.db"
+}
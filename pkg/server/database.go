@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	_ "modernc.org/sqlite"
+)
+
+type DB struct {
+	handler *sql.DB
+	path    string
+
+	log zerolog.Logger
+}
+
+func NewDB(path string) *DB {
+	return &DB{
+		path: path,
+		log:  log.Logger.With().Str("module", "database").Logger(),
+	}
+}
+
+func (db *DB) Open() error {
+	if db.path == "" {
+		return errors.New("database path can't be empty")
+	}
+
+	if dir := filepath.Dir(db.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "could not create database dir: %s", dir)
+		}
+	}
+
+	var err error
+	db.handler, err = sql.Open("sqlite", db.path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return errors.Wrapf(err, "could not open database: %s", db.path)
+	}
+
+	// sqlite only supports a single writer
+	db.handler.SetMaxOpenConns(1)
+
+	if err := db.migrate(); err != nil {
+		return errors.Wrap(err, "could not migrate database")
+	}
+
+	db.log.Debug().Msgf("opened database: %s", db.path)
+
+	return nil
+}
+
+func (db *DB) Close() error {
+	if db.handler == nil {
+		return nil
+	}
+
+	return db.handler.Close()
+}
+
+func (db *DB) migrate() error {
+	ctx := context.Background()
+
+	var version int
+	if err := db.handler.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return errors.Wrap(err, "could not get db version")
+	}
+
+	if version == len(migrations) {
+		return nil
+	}
+
+	if version > len(migrations) {
+		return errors.Errorf("database schema version %d is newer than supported version %d", version, len(migrations))
+	}
+
+	db.log.Info().Msgf("beginning migration: %d to %d", version, len(migrations))
+
+	tx, err := db.handler.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// new databases start from the initial schema and run every migration after it
+	for i := version; i < len(migrations); i++ {
+		db.log.Info().Msgf("migrate: %d", i+1)
+
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			return errors.Wrapf(err, "could not run migration: %d", i+1)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		return errors.Wrap(err, "could not bump schema version")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit migration")
+	}
+
+	db.log.Info().Msgf("database schema upgraded to version: %d", len(migrations))
+
+	return nil
+}
+
+// schema is the initial schema. Databases were already created from it, so changes go in new entries of migrations.
+const schema = `
+CREATE TABLE node
+(
+    name         TEXT PRIMARY KEY,
+    addr         TEXT NOT NULL,
+    token        TEXT NOT NULL,
+    labels       TEXT NOT NULL DEFAULT '{}',
+    status       TEXT NOT NULL,
+    date_created TIMESTAMP NOT NULL,
+    updated_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE task
+(
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    indexer    TEXT NOT NULL DEFAULT '',
+    category   TEXT NOT NULL DEFAULT '',
+    state      TEXT NOT NULL,
+    payload    TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX task_created_at_index ON task (created_at);
+
+CREATE TABLE task_replica
+(
+    task_id    TEXT NOT NULL REFERENCES task (id) ON DELETE CASCADE,
+    node       TEXT NOT NULL,
+    state      TEXT NOT NULL,
+    hash       TEXT NOT NULL DEFAULT '',
+    error      TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (task_id, node)
+);
+
+CREATE TABLE task_event
+(
+    id         TEXT PRIMARY KEY,
+    task_id    TEXT NOT NULL REFERENCES task (id) ON DELETE CASCADE,
+    prev_state TEXT NOT NULL,
+    state      TEXT NOT NULL,
+    node       TEXT NOT NULL DEFAULT '',
+    reason     TEXT NOT NULL DEFAULT '',
+    timestamp  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX task_event_task_id_index ON task_event (task_id);
+
+CREATE TABLE scheduling_decision
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id    TEXT NOT NULL REFERENCES task (id) ON DELETE CASCADE,
+    scheduler  TEXT NOT NULL,
+    candidates TEXT NOT NULL DEFAULT '[]',
+    scores     TEXT NOT NULL DEFAULT '{}',
+    picked     TEXT NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX scheduling_decision_task_id_index ON scheduling_decision (task_id);
+`
+
+// migrations upgrade existing databases one version at a time.
+// The first entry is the initial schema.
+var migrations = []string{
+	schema,
+	`ALTER TABLE node ADD COLUMN taints TEXT NOT NULL DEFAULT '[]';`,
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_migrate(t *testing.T) {
+	t.Run("new database", func(t *testing.T) {
+		db := NewDB(filepath.Join(t.TempDir(), "distribrr.db"))
+		require.NoError(t, db.Open())
+		defer db.Close()
+
+		n := node.NewNode("node0", "http://localhost:7430", "token", "worker")
+		n.Taints = []task.Taint{{Key: "disktype", Value: "hdd", Effect: task.TaintEffectNoSchedule}}
+		require.NoError(t, db.SaveNode(t.Context(), n))
+
+		nodes, err := db.ListNodes(t.Context())
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+		assert.Equal(t, n.Taints, nodes[0].Taints)
+	})
+
+	t.Run("database created from the initial schema", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "distribrr.db")
+
+		handler, err := sql.Open("sqlite", path)
+		require.NoError(t, err)
+
+		ctx := context.Background()
+		_, err = handler.ExecContext(ctx, schema)
+		require.NoError(t, err)
+		_, err = handler.ExecContext(ctx, "PRAGMA user_version = 1")
+		require.NoError(t, err)
+		_, err = handler.ExecContext(ctx, `INSERT INTO node (name, addr, token, labels, status, date_created, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			"node0", "http://localhost:7430", "token", `{"region":"eu"}`, string(node.StatusReady), time.Now().UTC(), time.Now().UTC())
+		require.NoError(t, err)
+		require.NoError(t, handler.Close())
+
+		db := NewDB(path)
+		require.NoError(t, db.Open())
+		defer db.Close()
+
+		nodes, err := db.ListNodes(t.Context())
+		require.NoError(t, err)
+		require.Len(t, nodes, 1)
+		assert.Equal(t, map[string]string{"region": "eu"}, nodes[0].Labels)
+		assert.Empty(t, nodes[0].Taints)
+	})
+}
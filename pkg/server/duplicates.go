@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DuplicateModeReject = "reject"
+	DuplicateModeMerge  = "merge"
+)
+
+// DuplicateTaskError is returned when a task with the same infohash was already received within the duplicate window.
+type DuplicateTaskError struct {
+	Hash       string
+	OriginalID uuid.UUID
+	Merged     bool
+}
+
+func (e *DuplicateTaskError) Error() string {
+	return fmt.Sprintf("duplicate of task %s with infohash %s", e.OriginalID, e.Hash)
+}
+
+type hashEntry struct {
+	taskID uuid.UUID
+	seen   time.Time
+}
+
+// hashIndex remembers the infohash of recent tasks.
+type hashIndex struct {
+	entries map[string]hashEntry
+	window  time.Duration
+	m       sync.Mutex
+}
+
+func newHashIndex(window time.Duration) *hashIndex {
+	return &hashIndex{
+		entries: map[string]hashEntry{},
+		window:  window,
+	}
+}
+
+// Claim records hash for the task unless another task still holds it within the window.
+// A held hash is released when isLive reports the original task as no longer live, like a failed task.
+func (h *hashIndex) Claim(hash string, id uuid.UUID, now time.Time, isLive func(uuid.UUID) bool) (uuid.UUID, bool) {
+	hash = strings.ToLower(hash)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.prune(now)
+
+	if entry, ok := h.entries[hash]; ok && entry.taskID != id && isLive(entry.taskID) {
+		return entry.taskID, false
+	}
+
+	h.entries[hash] = hashEntry{taskID: id, seen: now}
+
+	return id, true
+}
+
+// Release forgets the hash if it is still held by the task.
+func (h *hashIndex) Release(hash string, id uuid.UUID) {
+	hash = strings.ToLower(hash)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if entry, ok := h.entries[hash]; ok && entry.taskID == id {
+		delete(h.entries, hash)
+	}
+}
+
+func (h *hashIndex) prune(now time.Time) {
+	for hash, entry := range h.entries {
+		if now.Sub(entry.seen) > h.window {
+			delete(h.entries, hash)
+		}
+	}
+}
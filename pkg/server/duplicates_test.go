@@ -0,0 +1,132 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashIndex(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	live := func(uuid.UUID) bool { return true }
+
+	t.Run("claim", func(t *testing.T) {
+		h := newHashIndex(time.Hour)
+		a, b := uuid.New(), uuid.New()
+
+		got, ok := h.Claim("ABC", a, now, live)
+		assert.True(t, ok)
+		assert.Equal(t, a, got)
+
+		// hashes are case-insensitive
+		got, ok = h.Claim("abc", b, now, live)
+		assert.False(t, ok)
+		assert.Equal(t, a, got)
+
+		// claiming again as the holder is fine
+		_, ok = h.Claim("abc", a, now.Add(time.Minute), live)
+		assert.True(t, ok)
+	})
+
+	t.Run("window expiry", func(t *testing.T) {
+		h := newHashIndex(time.Hour)
+		a, b := uuid.New(), uuid.New()
+
+		_, ok := h.Claim("abc", a, now, live)
+		assert.True(t, ok)
+
+		_, ok = h.Claim("abc", b, now.Add(time.Hour), live)
+		assert.False(t, ok)
+
+		got, ok := h.Claim("abc", b, now.Add(time.Hour+time.Second), live)
+		assert.True(t, ok)
+		assert.Equal(t, b, got)
+	})
+
+	t.Run("release on failure", func(t *testing.T) {
+		h := newHashIndex(time.Hour)
+		a, b := uuid.New(), uuid.New()
+
+		_, ok := h.Claim("abc", a, now, live)
+		assert.True(t, ok)
+
+		// only the holder releases the hash
+		h.Release("abc", b)
+		_, ok = h.Claim("abc", b, now, live)
+		assert.False(t, ok)
+
+		h.Release("ABC", a)
+		_, ok = h.Claim("abc", b, now, live)
+		assert.True(t, ok)
+	})
+
+	t.Run("dead original", func(t *testing.T) {
+		h := newHashIndex(time.Hour)
+		a, b := uuid.New(), uuid.New()
+
+		_, ok := h.Claim("abc", a, now, live)
+		assert.True(t, ok)
+
+		got, ok := h.Claim("abc", b, now, func(id uuid.UUID) bool { return id != a })
+		assert.True(t, ok)
+		assert.Equal(t, b, got)
+	})
+}
+
+func TestService_isTaskLive(t *testing.T) {
+	s := NewService(&Config{}, nil)
+
+	failed := task.NewTask()
+	s.tasks.Add(failed, "task received")
+	_, err := s.tasks.Transition(failed.ID, task.Failed, "", "no ready nodes")
+	assert.NoError(t, err)
+
+	pending := task.NewTask()
+	s.tasks.Add(pending, "task received")
+
+	assert.False(t, s.isTaskLive(failed.ID))
+	assert.True(t, s.isTaskLive(pending.ID))
+
+	// a concurrent duplicate can't take over the claim of a task that isn't registered yet
+	h := newHashIndex(time.Hour)
+	first, second := uuid.New(), uuid.New()
+
+	_, ok := h.Claim("abc", first, time.Now(), s.isTaskLive)
+	assert.True(t, ok)
+
+	got, ok := h.Claim("abc", second, time.Now(), s.isTaskLive)
+	assert.False(t, ok)
+	assert.Equal(t, first, got)
+}
+
+func TestDuplicates_validate(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{mode: "", want: DuplicateModeReject},
+		{mode: "reject", want: DuplicateModeReject},
+		{mode: " Merge ", want: DuplicateModeMerge},
+		{mode: "drop", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			d := Duplicates{Window: time.Minute, Mode: tt.mode}
+
+			err := d.validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, d.Mode)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/autobrr/distribrr/internal/domain"
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/scheduler"
+	"github.com/autobrr/distribrr/pkg/task"
+)
+
+// ScheduleExplanation is a dry run of the scheduler for a task: why every node was or wasn't a candidate,
+// how the candidates scored, and which nodes would be picked.
+type ScheduleExplanation struct {
+	Scheduler string            `json:"scheduler"`
+	Route     string            `json:"route,omitempty"`
+	Replicas  int               `json:"replicas"`
+	Nodes     []NodeExplanation `json:"nodes"`
+	Picked    []string          `json:"picked"`
+	Notes     []string          `json:"notes,omitempty"`
+}
+
+// NodeExplanation is the filter verdict and score of a single node.
+type NodeExplanation struct {
+	Node      string               `json:"node"`
+	Status    node.Status          `json:"status"`
+	Candidate bool                 `json:"candidate"`
+	Reason    string               `json:"reason,omitempty"`
+	Score     *scheduler.Breakdown `json:"score,omitempty"`
+	Rank      int                  `json:"rank,omitempty"`
+	Picked    bool                 `json:"picked"`
+}
+
+// ExplainTask runs the scheduler for the task without dispatching it or reserving resources.
+// Routing rules are applied first. The torrent is not downloaded, so disk checks use the size of an attached
+// torrent, or the task size or disk request.
+func (s *Service) ExplainTask(ctx context.Context, t task.Task) ScheduleExplanation {
+	exp := ScheduleExplanation{
+		Route:  s.router.Apply(&t),
+		Nodes:  make([]NodeExplanation, 0),
+		Picked: make([]string, 0),
+	}
+
+	if len(t.Torrent) > 0 && t.Size == 0 {
+		rel := domain.NewRelease(t.DownloadURL, t.Name, t.Indexer)
+		if err := rel.LoadTorrentBytes(t.Torrent); err != nil {
+			exp.Notes = append(exp.Notes, fmt.Sprintf("could not read torrent: %v", err))
+		} else {
+			t.Size = rel.Size
+		}
+	}
+
+	name, sc := s.schedulerFor(t)
+	exp.Scheduler = name
+	exp.Replicas = max(t.MaxAllowedReplicas, 1)
+
+	if t.ForceAdd {
+		exp.Notes = append(exp.Notes, forceAddNote)
+	}
+
+	nodes := s.GetNodes()
+
+	// fetch stats like a real dispatch, before locking
+	_, _ = s.refreshStats(ctx, nodes)
+
+	s.schedMu.Lock()
+	defer s.schedMu.Unlock()
+
+	candidates, reasons := scheduler.Filter(ctx, t, nodes)
+
+	var breakdowns map[string]scheduler.Breakdown
+	if explainer, ok := sc.(scheduler.Explainer); ok {
+		breakdowns = explainer.Explain(ctx, t, candidates)
+	} else {
+		exp.Notes = append(exp.Notes, fmt.Sprintf("scheduler %s has no score breakdown, scoring it advances its state", name))
+
+		breakdowns = map[string]scheduler.Breakdown{}
+		for nodeName, score := range sc.Score(ctx, t, candidates) {
+			breakdowns[nodeName] = scheduler.Breakdown{Score: score, Parts: []scheduler.ScorePart{{Name: "score", Value: score}}}
+		}
+	}
+
+	scores := make(map[string]float64, len(breakdowns))
+	for nodeName, b := range breakdowns {
+		scores[nodeName] = b.Score
+	}
+
+	var picked []*node.Node
+	if len(candidates) > 0 {
+		picked, _ = scheduler.PickNodes(ctx, sc, t, scores, candidates)
+	}
+
+	for _, n := range picked {
+		exp.Picked = append(exp.Picked, n.Name)
+	}
+
+	ranked := sc.PickN(scores, slices.Clone(candidates), len(candidates))
+
+	for _, n := range nodes {
+		ne := NodeExplanation{
+			Node:   n.Name,
+			Status: n.Status,
+			Reason: reasons[n.Name],
+			Picked: slices.Contains(picked, n),
+		}
+
+		if slices.Contains(candidates, n) {
+			ne.Candidate = true
+			ne.Rank = slices.Index(ranked, n) + 1
+
+			if b, ok := breakdowns[n.Name]; ok {
+				ne.Score = &b
+			}
+		}
+
+		exp.Nodes = append(exp.Nodes, ne)
+	}
+
+	if len(candidates) == 0 {
+		exp.Notes = append(exp.Notes, "no candidate nodes, the task would wait for a ready node or fail")
+	}
+
+	if note := spreadNote(t, picked); note != "" && len(candidates) > 0 {
+		exp.Notes = append(exp.Notes, note)
+	}
+
+	if note := fallbackNote(t, exp.Picked); note != "" {
+		exp.Notes = append(exp.Notes, note)
+	}
+
+	return exp
+}
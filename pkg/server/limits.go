@@ -0,0 +1,300 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	IndexerLimitModeQueue  = "queue"
+	IndexerLimitModeReject = "reject"
+)
+
+// indexerSyncGrace is how long a dispatched task counts as downloading before the agents have to report it
+const indexerSyncGrace = time.Minute
+
+var ErrIndexerLimit = errors.New("indexer limit reached")
+
+// IndexerLimit caps the tasks of a single indexer across all nodes.
+type IndexerLimit struct {
+	// MaxConcurrent is the max number of tasks downloading at once. 0 is unlimited
+	MaxConcurrent int `yaml:"maxConcurrent"`
+	// MaxNew is the max number of tasks dispatched within Window. 0 is unlimited
+	MaxNew int           `yaml:"maxNew"`
+	Window time.Duration `yaml:"window"`
+	// Mode is queue to hold tasks over the limit until there is room, or reject to refuse them
+	Mode string `yaml:"mode"`
+}
+
+// IndexerUsage is the current usage of an indexer against its limit.
+type IndexerUsage struct {
+	Indexer       string        `json:"indexer"`
+	Mode          string        `json:"mode"`
+	Downloading   int           `json:"downloading"`
+	MaxConcurrent int           `json:"max_concurrent"`
+	Started       int           `json:"started"`
+	MaxNew        int           `json:"max_new"`
+	Window        time.Duration `json:"window"`
+	Queued        int           `json:"queued"`
+	Tasks         []uuid.UUID   `json:"tasks"`
+}
+
+type indexerTask struct {
+	hash    string
+	started time.Time
+}
+
+type indexerState struct {
+	active  map[uuid.UUID]indexerTask
+	started []time.Time
+}
+
+// indexerLimiter tracks the downloading tasks and recent dispatches of every limited indexer.
+type indexerLimiter struct {
+	limits map[string]IndexerLimit
+	state  map[string]*indexerState
+	m      sync.Mutex
+}
+
+func newIndexerLimiter(limits map[string]IndexerLimit) (*indexerLimiter, error) {
+	l := &indexerLimiter{
+		limits: map[string]IndexerLimit{},
+		state:  map[string]*indexerState{},
+	}
+
+	for indexer, limit := range limits {
+		switch limit.Mode {
+		case "":
+			limit.Mode = IndexerLimitModeQueue
+		case IndexerLimitModeQueue, IndexerLimitModeReject:
+		default:
+			return nil, errors.Errorf("indexer %s: unknown limit mode %q", indexer, limit.Mode)
+		}
+
+		if limit.MaxConcurrent < 0 || limit.MaxNew < 0 || limit.Window < 0 {
+			return nil, errors.Errorf("indexer %s: limits can't be negative", indexer)
+		}
+
+		key := normalizeIndexer(indexer)
+		l.limits[key] = limit
+		l.state[key] = &indexerState{active: map[uuid.UUID]indexerTask{}}
+	}
+
+	return l, nil
+}
+
+func normalizeIndexer(indexer string) string {
+	return strings.ToLower(strings.TrimSpace(indexer))
+}
+
+func (l *indexerLimiter) Enabled() bool {
+	return len(l.limits) > 0
+}
+
+// Rejects reports if tasks of the indexer are refused, rather than queued, when over the limit.
+func (l *indexerLimiter) Rejects(indexer string) bool {
+	limit, ok := l.limits[normalizeIndexer(indexer)]
+	return ok && limit.Mode == IndexerLimitModeReject
+}
+
+// Allow checks if a task of the indexer could be dispatched now, without counting it.
+func (l *indexerLimiter) Allow(indexer string, now time.Time) error {
+	key := normalizeIndexer(indexer)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.check(key, now)
+}
+
+// Acquire counts the task against its indexer limit, unless the limit is reached.
+func (l *indexerLimiter) Acquire(t task.Task, now time.Time) error {
+	key := normalizeIndexer(t.Indexer)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if err := l.check(key, now); err != nil {
+		return err
+	}
+
+	if st, ok := l.state[key]; ok {
+		st.active[t.ID] = indexerTask{hash: strings.ToLower(t.InfoHash), started: now}
+		st.started = append(st.started, now)
+	}
+
+	return nil
+}
+
+// RetryAt returns when the oldest dispatch of the indexer leaves its rate window, or zero if the indexer
+// isn't held back by the rate window.
+func (l *indexerLimiter) RetryAt(indexer string, now time.Time) time.Time {
+	key := normalizeIndexer(indexer)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	limit, ok := l.limits[key]
+	if !ok || limit.MaxNew <= 0 || limit.Window <= 0 {
+		return time.Time{}
+	}
+
+	st := l.state[key]
+	l.prune(key, now)
+
+	if len(st.started) < limit.MaxNew {
+		return time.Time{}
+	}
+
+	return slices.MinFunc(st.started, func(a, b time.Time) int { return a.Compare(b) }).Add(limit.Window)
+}
+
+// Release stops counting a task that was never dispatched, so it doesn't use up the window either.
+func (l *indexerLimiter) Release(t task.Task) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	st, ok := l.state[normalizeIndexer(t.Indexer)]
+	if !ok {
+		return
+	}
+
+	at, ok := st.active[t.ID]
+	if !ok {
+		return
+	}
+
+	delete(st.active, t.ID)
+
+	if idx := slices.Index(st.started, at.started); idx >= 0 {
+		st.started = slices.Delete(st.started, idx, idx+1)
+	}
+}
+
+// Restore counts a task dispatched before a restart. Sync drops it once it's done downloading.
+func (l *indexerLimiter) Restore(t task.Task, started time.Time) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	st, ok := l.state[normalizeIndexer(t.Indexer)]
+	if !ok {
+		return
+	}
+
+	st.active[t.ID] = indexerTask{hash: strings.ToLower(t.InfoHash), started: started}
+	st.started = append(st.started, started)
+}
+
+// Tracking reports if any task is counted as downloading.
+func (l *indexerLimiter) Tracking() bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for _, st := range l.state {
+		if len(st.active) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sync stops counting tasks that are no longer downloading on any node.
+// Tasks dispatched within indexerSyncGrace are kept, since the agents may not report them yet.
+func (l *indexerLimiter) Sync(now time.Time, downloading func(hash string) bool) int {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	released := 0
+
+	for _, st := range l.state {
+		for id, at := range st.active {
+			if now.Sub(at.started) < indexerSyncGrace || downloading(at.hash) {
+				continue
+			}
+
+			delete(st.active, id)
+			released++
+		}
+	}
+
+	return released
+}
+
+// Usage returns the usage of every limited indexer, sorted by name.
+func (l *indexerLimiter) Usage(now time.Time) []IndexerUsage {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	usage := make([]IndexerUsage, 0, len(l.limits))
+
+	for key, limit := range l.limits {
+		st := l.state[key]
+		l.prune(key, now)
+
+		u := IndexerUsage{
+			Indexer:       key,
+			Mode:          limit.Mode,
+			Downloading:   len(st.active),
+			MaxConcurrent: limit.MaxConcurrent,
+			Started:       len(st.started),
+			MaxNew:        limit.MaxNew,
+			Window:        limit.Window,
+			Tasks:         make([]uuid.UUID, 0, len(st.active)),
+		}
+
+		for id := range st.active {
+			u.Tasks = append(u.Tasks, id)
+		}
+
+		slices.SortFunc(u.Tasks, func(a, b uuid.UUID) int { return strings.Compare(a.String(), b.String()) })
+
+		usage = append(usage, u)
+	}
+
+	slices.SortFunc(usage, func(a, b IndexerUsage) int { return strings.Compare(a.Indexer, b.Indexer) })
+
+	return usage
+}
+
+func (l *indexerLimiter) check(key string, now time.Time) error {
+	limit, ok := l.limits[key]
+	if !ok {
+		return nil
+	}
+
+	st := l.state[key]
+	l.prune(key, now)
+
+	if limit.MaxConcurrent > 0 && len(st.active) >= limit.MaxConcurrent {
+		return errors.Wrapf(ErrIndexerLimit, "indexer %s has %d/%d tasks downloading", key, len(st.active), limit.MaxConcurrent)
+	}
+
+	if limit.MaxNew > 0 && limit.Window > 0 && len(st.started) >= limit.MaxNew {
+		return errors.Wrapf(ErrIndexerLimit, "indexer %s started %d/%d tasks in the last %s", key, len(st.started), limit.MaxNew, limit.Window)
+	}
+
+	return nil
+}
+
+// prune forgets dispatches older than the window
+func (l *indexerLimiter) prune(key string, now time.Time) {
+	st := l.state[key]
+
+	window := l.limits[key].Window
+	if window <= 0 {
+		st.started = st.started[:0]
+		return
+	}
+
+	st.started = slices.DeleteFunc(st.started, func(started time.Time) bool {
+		return now.Sub(started) >= window
+	})
+}
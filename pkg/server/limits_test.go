@@ -0,0 +1,170 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newIndexerTask(indexer string, hash string) task.Task {
+	t := task.NewTask()
+	t.Indexer = indexer
+	t.InfoHash = hash
+
+	return t
+}
+
+func TestIndexerLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("max concurrent", func(t *testing.T) {
+		l, err := newIndexerLimiter(map[string]IndexerLimit{"MyIndexer": {MaxConcurrent: 2}})
+		assert.NoError(t, err)
+
+		a, b, c := newIndexerTask("myindexer", "AAA"), newIndexerTask("MyIndexer", "bbb"), newIndexerTask("myindexer", "ccc")
+
+		assert.NoError(t, l.Acquire(a, now))
+		assert.NoError(t, l.Acquire(b, now))
+		assert.ErrorIs(t, l.Acquire(c, now), ErrIndexerLimit)
+
+		// other indexers are not limited
+		assert.NoError(t, l.Acquire(newIndexerTask("other", "ddd"), now))
+
+		// a finished downloading, b is still downloading
+		released := l.Sync(now.Add(indexerSyncGrace), func(hash string) bool { return hash == "bbb" })
+		assert.Equal(t, 1, released)
+		assert.NoError(t, l.Acquire(c, now.Add(indexerSyncGrace)))
+	})
+
+	t.Run("sync keeps tasks within grace", func(t *testing.T) {
+		l, err := newIndexerLimiter(map[string]IndexerLimit{"x": {MaxConcurrent: 1}})
+		assert.NoError(t, err)
+
+		assert.NoError(t, l.Acquire(newIndexerTask("x", "aaa"), now))
+		assert.Equal(t, 0, l.Sync(now.Add(time.Second), func(string) bool { return false }))
+		assert.True(t, l.Tracking())
+	})
+
+	t.Run("max new per window", func(t *testing.T) {
+		l, err := newIndexerLimiter(map[string]IndexerLimit{"x": {MaxNew: 2, Window: time.Hour}})
+		assert.NoError(t, err)
+
+		assert.NoError(t, l.Acquire(newIndexerTask("x", "a"), now))
+		assert.NoError(t, l.Acquire(newIndexerTask("x", "b"), now.Add(10*time.Minute)))
+		assert.ErrorIs(t, l.Allow("x", now.Add(30*time.Minute)), ErrIndexerLimit)
+
+		// the first task left the window
+		assert.NoError(t, l.Allow("x", now.Add(time.Hour)))
+	})
+
+	t.Run("retry at the end of the window", func(t *testing.T) {
+		l, err := newIndexerLimiter(map[string]IndexerLimit{"x": {MaxNew: 2, Window: time.Hour}, "y": {MaxConcurrent: 1}})
+		assert.NoError(t, err)
+
+		assert.Zero(t, l.RetryAt("x", now))
+
+		assert.NoError(t, l.Acquire(newIndexerTask("x", "a"), now.Add(10*time.Minute)))
+		assert.NoError(t, l.Acquire(newIndexerTask("x", "b"), now))
+		assert.Equal(t, now.Add(time.Hour), l.RetryAt("X", now.Add(30*time.Minute)))
+
+		// concurrency limits wait for downloads to finish instead
+		assert.NoError(t, l.Acquire(newIndexerTask("y", "c"), now))
+		assert.Zero(t, l.RetryAt("y", now))
+	})
+
+	t.Run("release undoes acquire", func(t *testing.T) {
+		l, err := newIndexerLimiter(map[string]IndexerLimit{"x": {MaxConcurrent: 1, MaxNew: 1, Window: time.Hour}})
+		assert.NoError(t, err)
+
+		a := newIndexerTask("x", "a")
+		assert.NoError(t, l.Acquire(a, now))
+		l.Release(a)
+
+		assert.NoError(t, l.Acquire(newIndexerTask("x", "b"), now))
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		l, err := newIndexerLimiter(map[string]IndexerLimit{"b": {MaxConcurrent: 1, Mode: IndexerLimitModeReject}, "a": {MaxNew: 5, Window: time.Hour}})
+		assert.NoError(t, err)
+
+		a := newIndexerTask("a", "a")
+		assert.NoError(t, l.Acquire(a, now))
+
+		assert.False(t, l.Rejects("a"))
+		assert.True(t, l.Rejects("B"))
+
+		usage := l.Usage(now)
+		assert.Len(t, usage, 2)
+		assert.Equal(t, "a", usage[0].Indexer)
+		assert.Equal(t, IndexerLimitModeQueue, usage[0].Mode)
+		assert.Equal(t, 1, usage[0].Downloading)
+		assert.Equal(t, 1, usage[0].Started)
+		assert.Equal(t, a.ID, usage[0].Tasks[0])
+		assert.Equal(t, "b", usage[1].Indexer)
+		assert.Equal(t, 0, usage[1].Downloading)
+	})
+
+	t.Run("invalid mode", func(t *testing.T) {
+		_, err := newIndexerLimiter(map[string]IndexerLimit{"x": {MaxConcurrent: 1, Mode: "drop"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("queue holds tasks over the limit", func(t *testing.T) {
+		l, err := newIndexerLimiter(map[string]IndexerLimit{"x": {MaxConcurrent: 1}})
+		assert.NoError(t, err)
+
+		q := NewTaskQueue(0, nil)
+		q.admit = func(t task.Task) bool { return l.Acquire(t, now) == nil }
+
+		x1, x2, y := newQueueItem("x1"), newQueueItem("x2"), newQueueItem("y")
+		x1.event.Task.Indexer = "x"
+		x2.event.Task.Indexer = "x"
+
+		for _, item := range []*queueItem{x1, x2, y} {
+			assert.NoError(t, q.Push(item))
+		}
+
+		got, _ := q.Pop()
+		assert.Equal(t, "x1", got.event.Task.Name)
+
+		// x2 waits for x1 to finish downloading, y passes it
+		next, _ := q.Pop()
+		assert.Equal(t, "y", next.event.Task.Name)
+		assert.Equal(t, 1, q.Position(x2.event.Task.ID))
+
+		l.Release(x1.event.Task)
+
+		last, _ := q.Pop()
+		assert.Equal(t, "x2", last.event.Task.Name)
+	})
+}
+
+func TestConfig_validate_indexers(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   IndexerLimit
+		wantErr string
+	}{
+		{name: "valid", limit: IndexerLimit{MaxConcurrent: 2, MaxNew: 5, Window: time.Hour, Mode: IndexerLimitModeReject}},
+		{name: "unknown mode", limit: IndexerLimit{MaxConcurrent: 2, Mode: "drop"}, wantErr: `invalid indexer limits: indexer alpha: unknown limit mode "drop"`},
+		{name: "negative", limit: IndexerLimit{MaxNew: -1}, wantErr: "invalid indexer limits: indexer alpha: limits can't be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Indexers = map[string]IndexerLimit{"alpha": tt.limit}
+
+			err := cfg.validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// parkedTasks holds tasks that found no ready nodes and are waiting, until their deadline, for one to become ready.
+type parkedTasks struct {
+	items []*queueItem
+	m     sync.Mutex
+}
+
+func newParkedTasks() *parkedTasks {
+	return &parkedTasks{
+		items: make([]*queueItem, 0),
+	}
+}
+
+func (p *parkedTasks) Park(item *queueItem) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.items = append(p.items, item)
+}
+
+// Drain removes and returns every parked task, oldest first.
+func (p *parkedTasks) Drain() []*queueItem {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	items := p.items
+	p.items = make([]*queueItem, 0)
+
+	return items
+}
+
+// Expire removes and returns every parked task past its deadline.
+func (p *parkedTasks) Expire(now time.Time) []*queueItem {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	expired := make([]*queueItem, 0)
+	kept := p.items[:0]
+
+	for _, item := range p.items {
+		if now.After(item.deadline) {
+			expired = append(expired, item)
+			continue
+		}
+		kept = append(kept, item)
+	}
+
+	p.items = kept
+
+	return expired
+}
+
+func (p *parkedTasks) Remove(id uuid.UUID) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for i, item := range p.items {
+		if item.event.Task.ID == id {
+			p.items = append(p.items[:i], p.items[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parkedTasks) Len() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return len(p.items)
+}
+
+func (p *parkedTasks) Refs() []QueuedTaskRef {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	now := time.Now()
+
+	refs := make([]QueuedTaskRef, 0, len(p.items))
+	for i, item := range p.items {
+		refs = append(refs, QueuedTaskRef{
+			TaskID:   item.event.Task.ID,
+			Name:     item.event.Task.Name,
+			Indexer:  item.event.Task.Indexer,
+			Priority: item.event.Task.Priority.OrDefault(),
+			Position: i + 1,
+			Enqueued: item.enqueued,
+			Wait:     now.Sub(item.enqueued),
+			Deadline: item.deadline,
+		})
+	}
+
+	return refs
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrQueueFull     = errors.New("task queue is full")
+	ErrQueueClosed   = errors.New("task queue is closed")
+	ErrTaskCancelled = errors.New("task cancelled")
+)
+
+type queueItem struct {
+	ctx      context.Context
+	event    task.Event
+	enqueued time.Time
+
+	// deadline is set once the task has been parked waiting for ready nodes
+	deadline time.Time
+
+	// result is nil for fire-and-forget items
+	result chan error
+}
+
+// TaskQueue is a bounded in-process queue of tasks waiting for a dispatch worker.
+// Tasks are dequeued by priority, and in arrival order within the same priority.
+type TaskQueue struct {
+	items   []*queueItem
+	maxSize int
+	closed  bool
+
+	// maxInFlight caps concurrent dispatches per priority. 0 or missing is unlimited
+	maxInFlight map[task.Priority]int
+
+	// admit is called for the next item before it is dequeued. Items it refuses stay queued
+	admit func(t task.Task) bool
+
+	inFlight         int
+	inFlightPriority map[task.Priority]int
+	lastWait         time.Duration
+
+	m    sync.Mutex
+	cond *sync.Cond
+
+	// wake is the pending NotifyAt timer. It has its own lock since admit runs with m held
+	wake   *time.Timer
+	wakeAt time.Time
+	wakeMu sync.Mutex
+}
+
+func NewTaskQueue(maxSize int, maxInFlight map[task.Priority]int) *TaskQueue {
+	q := &TaskQueue{
+		items:            make([]*queueItem, 0),
+		maxSize:          maxSize,
+		maxInFlight:      maxInFlight,
+		inFlightPriority: map[task.Priority]int{},
+	}
+	q.cond = sync.NewCond(&q.m)
+
+	return q
+}
+
+// Push adds an item behind every queued item of the same or higher priority. It never blocks.
+func (q *TaskQueue) Push(item *queueItem) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	if q.maxSize > 0 && len(q.items) >= q.maxSize {
+		return ErrQueueFull
+	}
+
+	if item.enqueued.IsZero() {
+		item.enqueued = time.Now().UTC()
+	}
+
+	rank := item.event.Task.Priority.Rank()
+
+	idx := len(q.items)
+	for i, queued := range q.items {
+		if queued.event.Task.Priority.Rank() < rank {
+			idx = i
+			break
+		}
+	}
+
+	q.items = slices.Insert(q.items, idx, item)
+	q.cond.Broadcast()
+
+	return nil
+}
+
+// next returns the index of the first item whose priority is below its in-flight cap, and that admit accepts, or -1.
+func (q *TaskQueue) next() int {
+	for i, item := range q.items {
+		priority := item.event.Task.Priority.OrDefault()
+
+		if limit := q.maxInFlight[priority]; limit > 0 && q.inFlightPriority[priority] >= limit {
+			continue
+		}
+
+		if q.admit != nil && !q.admit(item.event.Task) {
+			continue
+		}
+
+		return i
+	}
+
+	return -1
+}
+
+// Pop blocks until an item is available or the queue is closed.
+func (q *TaskQueue) Pop() (*queueItem, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	idx := q.next()
+	for idx < 0 {
+		if q.closed && len(q.items) == 0 {
+			return nil, false
+		}
+
+		q.cond.Wait()
+
+		idx = q.next()
+	}
+
+	item := q.items[idx]
+	q.items = slices.Delete(q.items, idx, idx+1)
+
+	q.inFlight++
+	q.inFlightPriority[item.event.Task.Priority.OrDefault()]++
+	q.lastWait = time.Since(item.enqueued)
+
+	return item, true
+}
+
+// Done marks an item returned by Pop as finished.
+func (q *TaskQueue) Done(item *queueItem) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.inFlight > 0 {
+		q.inFlight--
+	}
+
+	priority := item.event.Task.Priority.OrDefault()
+	if q.inFlightPriority[priority] > 0 {
+		q.inFlightPriority[priority]--
+	}
+
+	// a worker may be waiting for this priority to drop below its cap
+	q.cond.Broadcast()
+}
+
+// Remove drops a waiting task from the queue. Callers waiting on it get ErrTaskCancelled.
+func (q *TaskQueue) Remove(id uuid.UUID) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	for i, item := range q.items {
+		if item.event.Task.ID != id {
+			continue
+		}
+
+		q.items = slices.Delete(q.items, i, i+1)
+
+		if item.result != nil {
+			item.result <- ErrTaskCancelled
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Notify wakes up waiting workers to check again if a queued item can be admitted.
+func (q *TaskQueue) Notify() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.cond.Broadcast()
+}
+
+// NotifyAt wakes up waiting workers at the given time, for items that can't be admitted until then.
+// Only the earliest pending wake up is kept.
+func (q *TaskQueue) NotifyAt(at time.Time) {
+	q.wakeMu.Lock()
+	defer q.wakeMu.Unlock()
+
+	if q.wake != nil && !q.wakeAt.After(at) {
+		return
+	}
+
+	if q.wake != nil {
+		q.wake.Stop()
+	}
+
+	q.wakeAt = at
+	q.wake = time.AfterFunc(time.Until(at), func() {
+		q.wakeMu.Lock()
+		q.wake = nil
+		q.wakeMu.Unlock()
+
+		q.Notify()
+	})
+}
+
+func (q *TaskQueue) Close() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *TaskQueue) Len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	return len(q.items)
+}
+
+// Position returns the 1-based position of the task in the queue, or 0 if it is not queued.
+func (q *TaskQueue) Position(id uuid.UUID) int {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	for i, item := range q.items {
+		if item.event.Task.ID == id {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+type QueueStats struct {
+	Depth      int                   `json:"depth"`
+	MaxSize    int                   `json:"max_size"`
+	Workers    int                   `json:"workers"`
+	InFlight   int                   `json:"in_flight"`
+	ByPriority map[task.Priority]int `json:"in_flight_by_priority"`
+	OldestWait time.Duration         `json:"oldest_wait"`
+	LastWait   time.Duration         `json:"last_wait"`
+	Items      []QueuedTaskRef       `json:"items"`
+	Parked     []QueuedTaskRef       `json:"parked"`
+}
+
+type QueuedTaskRef struct {
+	TaskID   uuid.UUID     `json:"task_id"`
+	Name     string        `json:"name"`
+	Indexer  string        `json:"indexer"`
+	Priority task.Priority `json:"priority"`
+	Position int           `json:"position"`
+	Enqueued time.Time     `json:"enqueued"`
+	Wait     time.Duration `json:"wait"`
+	Deadline time.Time     `json:"deadline,omitzero"`
+}
+
+func (q *TaskQueue) Stats() QueueStats {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	now := time.Now()
+
+	qs := QueueStats{
+		Depth:      len(q.items),
+		MaxSize:    q.maxSize,
+		InFlight:   q.inFlight,
+		ByPriority: maps.Clone(q.inFlightPriority),
+		LastWait:   q.lastWait,
+		Items:      make([]QueuedTaskRef, 0, len(q.items)),
+	}
+
+	for i, item := range q.items {
+		// items are in priority order, so the oldest one can be anywhere
+		wait := now.Sub(item.enqueued)
+		if wait > qs.OldestWait {
+			qs.OldestWait = wait
+		}
+
+		qs.Items = append(qs.Items, QueuedTaskRef{
+			TaskID:   item.event.Task.ID,
+			Name:     item.event.Task.Name,
+			Indexer:  item.event.Task.Indexer,
+			Priority: item.event.Task.Priority.OrDefault(),
+			Position: i + 1,
+			Enqueued: item.enqueued,
+			Wait:     wait,
+			Deadline: item.deadline,
+		})
+	}
+
+	return qs
+}
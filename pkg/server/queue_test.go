@@ -0,0 +1,104 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newQueueItem(name string) *queueItem {
+	te := task.NewEvent()
+	te.Task = task.NewTask()
+	te.Task.Name = name
+
+	return &queueItem{event: te}
+}
+
+func TestTaskQueue(t *testing.T) {
+	t.Run("fifo order and positions", func(t *testing.T) {
+		q := NewTaskQueue(0, nil)
+
+		a, b, c := newQueueItem("a"), newQueueItem("b"), newQueueItem("c")
+		for _, item := range []*queueItem{a, b, c} {
+			assert.NoError(t, q.Push(item))
+		}
+
+		assert.Equal(t, 3, q.Len())
+		assert.Equal(t, 2, q.Position(b.event.Task.ID))
+
+		got, ok := q.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, "a", got.event.Task.Name)
+		assert.Equal(t, 0, q.Position(a.event.Task.ID))
+		assert.Equal(t, 1, q.Position(b.event.Task.ID))
+
+		qs := q.Stats()
+		assert.Equal(t, 2, qs.Depth)
+		assert.Equal(t, 1, qs.InFlight)
+
+		q.Done(got)
+		assert.Equal(t, 0, q.Stats().InFlight)
+	})
+
+	t.Run("priority order", func(t *testing.T) {
+		q := NewTaskQueue(0, nil)
+
+		backfill, normal, critical, normal2 := newQueueItem("backfill"), newQueueItem("normal"), newQueueItem("critical"), newQueueItem("normal2")
+		backfill.event.Task.Priority = task.PriorityBackfill
+		critical.event.Task.Priority = task.PriorityCritical
+
+		for _, item := range []*queueItem{backfill, normal, critical, normal2} {
+			assert.NoError(t, q.Push(item))
+		}
+
+		for _, want := range []string{"critical", "normal", "normal2", "backfill"} {
+			got, ok := q.Pop()
+			assert.True(t, ok)
+			assert.Equal(t, want, got.event.Task.Name)
+		}
+	})
+
+	t.Run("skips priority at in-flight cap", func(t *testing.T) {
+		q := NewTaskQueue(0, map[task.Priority]int{task.PriorityBackfill: 1})
+
+		b1, b2, n := newQueueItem("b1"), newQueueItem("b2"), newQueueItem("n")
+		b1.event.Task.Priority = task.PriorityBackfill
+		b2.event.Task.Priority = task.PriorityBackfill
+
+		assert.NoError(t, q.Push(b1))
+		assert.NoError(t, q.Push(b2))
+
+		got, _ := q.Pop()
+		assert.Equal(t, "b1", got.event.Task.Name)
+
+		// b2 is held back until b1 is done, normal tasks pass it
+		assert.NoError(t, q.Push(n))
+
+		next, _ := q.Pop()
+		assert.Equal(t, "n", next.event.Task.Name)
+		assert.Equal(t, 1, q.Len())
+
+		q.Done(got)
+
+		last, _ := q.Pop()
+		assert.Equal(t, "b2", last.event.Task.Name)
+	})
+
+	t.Run("rejects when full", func(t *testing.T) {
+		q := NewTaskQueue(1, nil)
+
+		assert.NoError(t, q.Push(newQueueItem("a")))
+		assert.ErrorIs(t, q.Push(newQueueItem("b")), ErrQueueFull)
+	})
+
+	t.Run("pop returns false after close", func(t *testing.T) {
+		q := NewTaskQueue(0, nil)
+		q.Close()
+
+		_, ok := q.Pop()
+		assert.False(t, ok)
+		assert.ErrorIs(t, q.Push(newQueueItem("a")), ErrQueueClosed)
+	})
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/autobrr/distribrr/pkg/scheduler"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/pkg/errors"
+)
+
+// RoutingRule applies scheduling policy to tasks by indexer, category or release name,
+// so it doesn't have to be repeated in every webhook payload.
+type RoutingRule struct {
+	Name  string       `yaml:"name"`
+	Match RoutingMatch `yaml:"match"`
+
+	// Labels are merged into the task labels, replacing values for the same key
+	Labels map[string]string `yaml:"labels"`
+	// Replicas replaces max_replicas when set
+	Replicas int `yaml:"replicas"`
+	// SchedulerType replaces scheduler_type when set
+	SchedulerType string `yaml:"schedulerType"`
+	// Priority replaces priority when set
+	Priority string `yaml:"priority"`
+	// Tags are appended to the task tags, comma separated
+	Tags string `yaml:"tags"`
+}
+
+// RoutingMatch fields are all optional, and every field that is set has to match.
+// Indexer and category are compared case-insensitively, name is a regex over the release name.
+type RoutingMatch struct {
+	Indexer  string `yaml:"indexer"`
+	Category string `yaml:"category"`
+	Name     string `yaml:"name"`
+}
+
+type route struct {
+	rule     RoutingRule
+	name     *regexp.Regexp
+	priority task.Priority
+}
+
+// router applies the first matching routing rule to tasks.
+type router struct {
+	routes []route
+}
+
+func newRouter(rules []RoutingRule) (*router, error) {
+	r := &router{routes: make([]route, 0, len(rules))}
+
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		rt := route{rule: rule}
+
+		if rule.Match.Name != "" {
+			re, err := regexp.Compile(rule.Match.Name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "routing %s: invalid name regex", rule.Name)
+			}
+			rt.name = re
+		}
+
+		if rule.Priority != "" {
+			priority, err := task.ParsePriority(rule.Priority)
+			if err != nil {
+				return nil, errors.Wrapf(err, "routing %s", rule.Name)
+			}
+			rt.priority = priority
+		}
+
+		if rule.SchedulerType != "" && !scheduler.Exists(rule.SchedulerType) {
+			return nil, errors.Wrapf(scheduler.ErrUnknownScheduler, "routing %s: %q", rule.Name, rule.SchedulerType)
+		}
+
+		r.routes = append(r.routes, rt)
+	}
+
+	return r, nil
+}
+
+// Apply updates the task with the first matching rule and returns its name, or "" if none matched.
+func (r *router) Apply(t *task.Task) string {
+	for _, rt := range r.routes {
+		if !rt.matches(t) {
+			continue
+		}
+
+		rt.apply(t)
+
+		return rt.rule.Name
+	}
+
+	return ""
+}
+
+func (rt route) matches(t *task.Task) bool {
+	m := rt.rule.Match
+
+	if m.Indexer != "" && !strings.EqualFold(m.Indexer, t.Indexer) {
+		return false
+	}
+
+	if m.Category != "" && !strings.EqualFold(m.Category, t.Category) {
+		return false
+	}
+
+	if rt.name != nil && !rt.name.MatchString(t.Name) {
+		return false
+	}
+
+	return true
+}
+
+func (rt route) apply(t *task.Task) {
+	rule := rt.rule
+
+	if len(rule.Labels) > 0 {
+		labels := maps.Clone(t.Labels)
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		maps.Copy(labels, rule.Labels)
+		t.Labels = labels
+	}
+
+	if rule.Replicas > 0 {
+		t.MaxAllowedReplicas = rule.Replicas
+	}
+
+	if rule.SchedulerType != "" {
+		t.SchedulerType = rule.SchedulerType
+	}
+
+	if rt.priority != "" {
+		t.Priority = rt.priority
+	}
+
+	if rule.Tags != "" {
+		t.Tags = mergeTags(t.Tags, rule.Tags)
+	}
+}
+
+// mergeTags appends the comma separated extra tags that aren't in tags yet
+func mergeTags(tags string, extra string) string {
+	var merged []string
+	for _, tag := range strings.Split(tags+","+extra, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(merged, tag) {
+			merged = append(merged, tag)
+		}
+	}
+
+	return strings.Join(merged, ",")
+}
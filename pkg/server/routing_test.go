@@ -0,0 +1,109 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	r, err := newRouter([]RoutingRule{
+		{
+			Name:     "btn 2160p",
+			Match:    RoutingMatch{Indexer: "btn", Name: `(?i)2160p`},
+			Labels:   map[string]string{"disktype": "nvme"},
+			Replicas: 3,
+			Priority: "critical",
+			Tags:     "uhd",
+		},
+		{
+			Name:          "btn",
+			Match:         RoutingMatch{Indexer: "BTN"},
+			SchedulerType: "roundrobin",
+			Tags:          "btn,race",
+		},
+		{
+			Match:    RoutingMatch{Category: "archive"},
+			Priority: "backfill",
+		},
+	})
+	assert.NoError(t, err)
+
+	t.Run("first match wins", func(t *testing.T) {
+		tsk := task.Task{Indexer: "btn", Name: "Show.S01E01.2160p.WEB", Labels: map[string]string{"region": "eu", "disktype": "hdd"}, MaxAllowedReplicas: 1, Tags: "race"}
+
+		assert.Equal(t, "btn 2160p", r.Apply(&tsk))
+		assert.Equal(t, map[string]string{"region": "eu", "disktype": "nvme"}, tsk.Labels)
+		assert.Equal(t, 3, tsk.MaxAllowedReplicas)
+		assert.Equal(t, task.PriorityCritical, tsk.Priority)
+		assert.Equal(t, "race,uhd", tsk.Tags)
+		assert.Empty(t, tsk.SchedulerType)
+	})
+
+	t.Run("indexer is case-insensitive", func(t *testing.T) {
+		tsk := task.Task{Indexer: "btn", Name: "Show.S01E01.1080p.WEB", MaxAllowedReplicas: 2, Tags: "race"}
+
+		assert.Equal(t, "btn", r.Apply(&tsk))
+		assert.Equal(t, "roundrobin", tsk.SchedulerType)
+		assert.Equal(t, 2, tsk.MaxAllowedReplicas)
+		assert.Equal(t, "race,btn", tsk.Tags)
+		assert.Nil(t, tsk.Labels)
+	})
+
+	t.Run("unnamed rule", func(t *testing.T) {
+		tsk := task.Task{Indexer: "other", Category: "Archive"}
+
+		assert.Equal(t, "rule 3", r.Apply(&tsk))
+		assert.Equal(t, task.PriorityBackfill, tsk.Priority)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		tsk := task.Task{Indexer: "other", Category: "tv"}
+
+		assert.Empty(t, r.Apply(&tsk))
+		assert.Equal(t, task.Task{Indexer: "other", Category: "tv"}, tsk)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		_, err := newRouter([]RoutingRule{{Match: RoutingMatch{Name: "("}}})
+		assert.Error(t, err)
+
+		_, err = newRouter([]RoutingRule{{Priority: "urgent"}})
+		assert.Error(t, err)
+
+		_, err = newRouter([]RoutingRule{{SchedulerType: "missing"}})
+		assert.Error(t, err)
+	})
+}
+
+func TestConfig_validate_routing(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    RoutingRule
+		wantErr string
+	}{
+		{name: "valid", rule: RoutingRule{Match: RoutingMatch{Name: `(?i)2160p`}, SchedulerType: "greedy", Priority: "critical"}},
+		{name: "bad regex", rule: RoutingRule{Name: "uhd", Match: RoutingMatch{Name: `(2160p`}}, wantErr: "invalid routing rules: routing uhd: invalid name regex"},
+		{name: "unknown scheduler", rule: RoutingRule{Name: "fast", SchedulerType: "fastest"}, wantErr: `invalid routing rules: routing fast: "fastest"`},
+		{name: "unknown priority", rule: RoutingRule{Name: "urgent", Priority: "urgent"}, wantErr: "invalid routing rules: routing urgent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Routing = []RoutingRule{tt.rule}
+
+			err := cfg.validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
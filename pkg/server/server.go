@@ -6,49 +6,286 @@ import (
 	"os"
 	"os/signal"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/autobrr/distribrr/internal/domain"
+	"github.com/autobrr/distribrr/pkg/agent"
 	"github.com/autobrr/distribrr/pkg/logger"
 	"github.com/autobrr/distribrr/pkg/node"
 	"github.com/autobrr/distribrr/pkg/scheduler"
+	"github.com/autobrr/distribrr/pkg/stats"
 	"github.com/autobrr/distribrr/pkg/task"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
 )
 
+var (
+	ErrNoReadyNodes = errors.New("no ready nodes available to handle the task")
+	ErrTaskParked   = errors.New("no ready nodes available, task is waiting for one")
+)
+
+// forceAddNote is recorded on force added tasks. Resource requests are still checked, only the clients may be full.
+const forceAddNote = "force add: skipping client readiness and download slot checks, cpu, memory and disk requests still apply"
+
 type Service struct {
 	cfg         *Config
+	db          *DB
 	workerNodes []*node.Node
 	m           sync.RWMutex
 
+	// schedMu serializes node selection and resource allocation, and guards setting the node stats they read
+	schedMu sync.Mutex
+
+	// schedulers holds one instance per registered scheduler so they can keep state between tasks
+	schedulers map[string]scheduler.Scheduler
+
+	router *router
+	limits *indexerLimiter
+
+	queue  *TaskQueue
+	parked *parkedTasks
+	tasks  *taskRegistry
+	hashes *hashIndex
+
 	log zerolog.Logger
 }
 
-func NewService(cfg *Config) *Service {
+func NewService(cfg *Config, db *DB) *Service {
 	s := &Service{
 		cfg:         cfg,
+		db:          db,
 		workerNodes: make([]*node.Node, 0),
 		log:         log.Logger.With().Str("module", "server").Logger(),
 		m:           sync.RWMutex{},
+		queue:       NewTaskQueue(cfg.Queue.Size, cfg.Queue.priorityLimits()),
+		parked:      newParkedTasks(),
+		hashes:      newHashIndex(cfg.Duplicates.Window),
+		tasks:       newTaskRegistry(db),
+		schedulers:  map[string]scheduler.Scheduler{},
+	}
+
+	for _, name := range scheduler.Names() {
+		sc, err := scheduler.New(name)
+		if err != nil {
+			s.log.Error().Err(err).Msgf("could not create scheduler %s", name)
+			continue
+		}
+		s.schedulers[name] = sc
+	}
+
+	if _, ok := s.schedulers[s.defaultScheduler()]; !ok {
+		s.log.Warn().Msgf("unknown default scheduler %q, using %s", cfg.Scheduler.Default, scheduler.DefaultScheduler)
+		cfg.Scheduler.Default = scheduler.DefaultScheduler
+	}
+
+	router, err := newRouter(cfg.Routing)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not load routing rules, routing is disabled")
+		router, _ = newRouter(nil)
+	}
+	s.router = router
+
+	limits, err := newIndexerLimiter(cfg.Indexers)
+	if err != nil {
+		s.log.Error().Err(err).Msg("could not load indexer limits, indexer limits are disabled")
+		limits, _ = newIndexerLimiter(nil)
+	}
+	s.limits = limits
+
+	if s.limits.Enabled() {
+		s.queue.admit = s.admitTask
+	}
+
+	for name, taints := range cfg.Taints {
+		cfg.Taints[name] = s.validTaints(name, taints)
 	}
 
 	s.m.Lock()
 	for _, w := range cfg.Nodes {
 		if w != nil {
-			s.workerNodes = append(s.workerNodes, node.NewNode(w.Name, w.Addr, w.Token, "worker"))
+			n := node.NewNode(w.Name, w.Addr, w.Token, "worker")
+			n.ServerTaints = cfg.Taints[w.Name]
+			s.workerNodes = append(s.workerNodes, n)
 		}
 	}
 	s.m.Unlock()
 
+	if err := s.loadNodes(context.Background()); err != nil {
+		s.log.Error().Err(err).Msg("could not load nodes from database")
+	}
+
+	if err := s.tasks.Load(context.Background()); err != nil {
+		s.log.Error().Err(err).Msg("could not load tasks from database")
+	}
+
+	s.pruneTasks()
+
+	s.loadHashes()
+	s.loadIndexerUsage()
+
 	return s
 }
 
+// loadHashes fills the duplicate index with recent tasks.
+func (s *Service) loadHashes() {
+	if s.cfg.Duplicates.Window <= 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+
+	for _, rec := range s.tasks.Recent(now.Add(-s.cfg.Duplicates.Window)) {
+		if rec.Task.InfoHash == "" || rec.Task.State == task.Failed {
+			continue
+		}
+
+		s.hashes.Claim(rec.Task.InfoHash, rec.Task.ID, rec.Created, s.isTaskLive)
+	}
+}
+
+// loadIndexerUsage counts tasks dispatched before a restart against their indexer limits.
+func (s *Service) loadIndexerUsage() {
+	if !s.limits.Enabled() {
+		return
+	}
+
+	for _, rec := range s.tasks.Recent(time.Time{}) {
+		if rec.Task.State != task.Scheduled && rec.Task.State != task.Running {
+			continue
+		}
+
+		s.limits.Restore(rec.Task, rec.Created)
+	}
+}
+
+// pruneTasks forgets finished tasks once they are past the retention.
+func (s *Service) pruneTasks() {
+	if s.cfg.Tasks.Retention <= 0 {
+		return
+	}
+
+	pruned := s.tasks.Prune(time.Now().UTC().Add(-s.cfg.Tasks.Retention))
+
+	for _, rec := range pruned {
+		// forgotten tasks would block duplicates of them for good
+		s.hashes.Release(rec.Task.InfoHash, rec.Task.ID)
+	}
+
+	if len(pruned) > 0 {
+		s.log.Debug().Msgf("pruned %d tasks older than %s", len(pruned), s.cfg.Tasks.Retention)
+	}
+}
+
+// isTaskLive reports if a task still blocks duplicates of it. Tasks that claimed their hash but aren't registered
+// yet are live, so concurrent duplicates can't take over the claim.
+func (s *Service) isTaskLive(id uuid.UUID) bool {
+	state, ok := s.tasks.State(id)
+	return !ok || state != task.Failed
+}
+
+// prepareTask downloads and validates the torrent once so agents don't have to hit the indexer,
+// then rejects the task if another live task with the same infohash was received within the duplicate window.
+func (s *Service) prepareTask(ctx context.Context, t *task.Task) error {
+	l := logger.GetWithCtx(ctx)
+
+	rel := domain.NewRelease(t.DownloadURL, t.Name, t.Indexer)
+
+	if len(t.Torrent) > 0 {
+		if err := rel.LoadTorrentBytes(t.Torrent); err != nil {
+			return errors.Wrap(err, "invalid torrent")
+		}
+	} else {
+		if err := rel.DownloadTorrentFile(ctx); err != nil {
+			return errors.Wrap(err, "could not download torrent")
+		}
+
+		l.Debug().Msgf("downloaded torrent %s: %s", t.Name, rel.Hash)
+	}
+
+	t.InfoHash = rel.Hash
+	t.Size = rel.Size
+	t.Torrent = rel.TorrentBytes
+
+	if s.cfg.Duplicates.Window <= 0 {
+		return nil
+	}
+
+	originalID, ok := s.hashes.Claim(t.InfoHash, t.ID, time.Now().UTC(), s.isTaskLive)
+	if ok {
+		return nil
+	}
+
+	dupErr := &DuplicateTaskError{
+		Hash:       t.InfoHash,
+		OriginalID: originalID,
+	}
+
+	l.Info().Msgf("task %s from %s is a duplicate of task %s", t.Name, t.Indexer, originalID)
+
+	if s.cfg.Duplicates.Mode == DuplicateModeMerge {
+		if err := s.tasks.Note(originalID, fmt.Sprintf("merged duplicate from indexer %q", t.Indexer)); err != nil {
+			l.Error().Err(err).Msgf("could not merge duplicate into task %s", originalID)
+		} else {
+			dupErr.Merged = true
+		}
+	}
+
+	return dupErr
+}
+
+// validTaints drops taints with an empty key or unknown effect.
+func (s *Service) validTaints(nodeName string, taints []task.Taint) []task.Taint {
+	return slices.DeleteFunc(slices.Clone(taints), func(t task.Taint) bool {
+		if err := t.Validate(); err != nil {
+			s.log.Warn().Err(err).Msgf("ignoring invalid taint for node %s", nodeName)
+			return true
+		}
+		return false
+	})
+}
+
+// loadNodes adds registered nodes from the database. Nodes from the config file take precedence.
+func (s *Service) loadNodes(ctx context.Context) error {
+	if s.db == nil {
+		return nil
+	}
+
+	nodes, err := s.db.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, n := range nodes {
+		if slices.ContainsFunc(s.workerNodes, func(w *node.Node) bool { return w.Name == n.Name }) {
+			continue
+		}
+
+		n.ServerTaints = s.cfg.Taints[n.Name]
+
+		// health checks decide if the node is ready
+		if n.Status != node.StatusRemoved {
+			n.Status = node.StatusNotReady
+		}
+
+		s.workerNodes = append(s.workerNodes, n)
+	}
+
+	s.log.Debug().Msgf("loaded %d nodes from database", len(nodes))
+
+	return nil
+}
+
 func (s *Service) Run() {
 	srv := NewAPIServer(s.cfg, s)
 
@@ -60,11 +297,20 @@ func (s *Service) Run() {
 	//go s.HealthChecks()
 	go s.HealthChecks()
 
+	s.ProcessTasks()
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM)
 
 	for sig := range sigCh {
 		log.Info().Msgf("got signal %q, shutting down server", sig)
+
+		if s.db != nil {
+			if err := s.db.Close(); err != nil {
+				log.Error().Err(err).Msg("could not close database")
+			}
+		}
+
 		os.Exit(0)
 	}
 }
@@ -81,58 +327,50 @@ func (s *Service) OnRegister(ctx context.Context, req RegisterRequest) error {
 
 	newNode := node.NewNode(req.NodeName, req.ClientAddr, req.AgentToken, "worker")
 	newNode.Labels = req.Labels
+	newNode.Taints = s.validTaints(req.NodeName, req.Taints)
+	newNode.ServerTaints = s.cfg.Taints[req.NodeName]
 
 	if err := newNode.VerifyToken(ctx); err != nil {
 		s.log.Error().Err(err).Msgf("could not verify agent token")
 		return err
 	}
 
-	// check s.workerNodes if it includes item by req.NodeName
-	exists := slices.ContainsFunc(s.workerNodes, func(n *node.Node) bool {
-		return n.Name == req.NodeName && n.Addr == req.ClientAddr
-	})
+	s.m.Lock()
 
-	if exists {
-		l.Debug().Msgf("node already exists in config: %s", req.NodeName)
+	idx := slices.IndexFunc(s.workerNodes, func(n *node.Node) bool {
+		return n.Name == req.NodeName
+	})
 
-		for _, workerNode := range s.workerNodes {
-			if workerNode.Name == req.NodeName {
-				// update labels
-				workerNode.Labels = req.Labels
-				workerNode.Status = node.StatusReady
-			}
-		}
+	registered := newNode
+	if idx >= 0 && s.workerNodes[idx].Addr == req.ClientAddr && s.workerNodes[idx].Token == req.AgentToken {
+		l.Debug().Msgf("node already registered: %s", req.NodeName)
 
-		return nil
-	}
+		// update labels
+		registered = s.workerNodes[idx]
+		registered.Labels = req.Labels
+		registered.Taints = newNode.Taints
+		registered.Status = node.StatusReady
+	} else if idx >= 0 {
+		l.Info().Msgf("on register: node %s changed address to %s", req.NodeName, req.ClientAddr)
 
-	s.workerNodes = append(s.workerNodes, newNode)
+		newNode.DateCreated = s.workerNodes[idx].DateCreated
+		s.workerNodes[idx] = newNode
+	} else {
+		l.Info().Msgf("on register: new node %s %s", req.NodeName, req.ClientAddr)
 
-	if err := s.appendNodeToConfig(ctx, req.NodeName, req.ClientAddr, req.AgentToken); err != nil {
-		l.Error().Err(err).Msgf("could not write node to config")
-		return err
+		s.workerNodes = append(s.workerNodes, newNode)
 	}
 
-	l.Info().Msgf("on register: new node %s %s", req.NodeName, req.ClientAddr)
-
-	return nil
-}
-
-func (s *Service) appendNodeToConfig(_ context.Context, nodeName string, clientAddr string, token string) error {
-	log.Debug().Msgf("append node to config: %s %s", nodeName, clientAddr)
+	s.m.Unlock()
 
-	a := AgentNode{
-		Name:  nodeName,
-		Addr:  clientAddr,
-		Token: token,
+	if s.db != nil {
+		if err := s.db.SaveNode(ctx, registered); err != nil {
+			l.Error().Err(err).Msgf("could not save node")
+			return err
+		}
 	}
 
-	s.cfg.Nodes = append(s.cfg.Nodes, &a)
-
-	if err := s.cfg.WriteToFile(); err != nil {
-		log.Error().Err(err).Msgf("could not write node to config")
-		return errors.Wrap(err, "could not write node to config")
-	}
+	s.releaseParkedTasks()
 
 	return nil
 }
@@ -140,47 +378,22 @@ func (s *Service) appendNodeToConfig(_ context.Context, nodeName string, clientA
 func (s *Service) Deregister(ctx context.Context, req DeregisterRequest) error {
 	log.Info().Msgf("deregister: node %s", req.NodeName)
 
+	s.m.RLock()
 	for _, workerNode := range s.workerNodes {
 		if workerNode.Name == req.NodeName {
-			//s.workerNodes = append(s.workerNodes[:i], s.workerNodes[i+1:]...)
 			workerNode.Status = node.StatusRemoved
 			break
 		}
 	}
+	s.m.RUnlock()
 
-	//slices.DeleteFunc(s.workerNodes, func(node *node.Node) bool {
-	//	return node.Name == req.NodeName
-	//})
-
-	//if err := s.removeNodeFromConfig(ctx, req.NodeName); err != nil {
-	//	return err
-	//}
-
-	return nil
-}
-
-func (s *Service) removeNodeFromConfig(ctx context.Context, nodeName string) error {
-	log.Debug().Msgf("remove node from config: node %s", nodeName)
-
-	// remove from config slice
-	slices.DeleteFunc(s.cfg.Nodes, func(agentNode *AgentNode) bool {
-		return agentNode.Name == nodeName
-	})
-
-	l := log.Ctx(ctx)
-
-	if len(s.cfg.Nodes) == 0 {
-		s.cfg.Nodes = []*AgentNode{}
-	}
-
-	// remove from config file
-	if err := s.cfg.WriteToFile(); err != nil {
-		l.Error().Err(err).Msgf("could not write node to config")
-		return err
+	if s.db != nil {
+		if err := s.db.UpdateNodeStatus(ctx, req.NodeName, node.StatusRemoved); err != nil {
+			log.Error().Err(err).Msgf("could not update node status")
+			return err
+		}
 	}
 
-	log.Info().Msgf("deregister: node %s", nodeName)
-
 	return nil
 }
 
@@ -204,6 +417,14 @@ func (s *Service) HealthChecks() {
 			if err := s.healthChecks(ctx); err != nil {
 				s.log.Error().Err(err).Msg("health checks failed")
 			}
+
+			s.releaseParkedTasks()
+
+			s.syncIndexerUsage(ctx)
+
+			s.syncCompletedTasks(ctx)
+
+			s.pruneTasks()
 		}
 	}
 }
@@ -248,156 +469,966 @@ func (s *Service) healthChecks(ctx context.Context) error {
 	return nil
 }
 
+// ProcessTasks starts the dispatch worker pool that drains the task queue.
 func (s *Service) ProcessTasks() {
-	ticker := time.NewTicker(10 * time.Second)
-	done := make(chan bool)
+	workers := s.cfg.Queue.Workers
+	if workers <= 0 {
+		workers = 1
+	}
 
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			case t := <-ticker.C:
-				fmt.Println("Tick at", t)
-				log.Debug().Msgf("Processing task queue")
-
-				// FIXME change to read from queue
-				s.SendWork(context.Background(), task.NewEvent())
-				log.Debug().Msgf("sleeping for 10 seconds...")
-			}
+	s.log.Debug().Msgf("starting %d task dispatch workers", workers)
+
+	for i := 0; i < workers; i++ {
+		go s.dispatchWorker(i)
+	}
+}
+
+func (s *Service) dispatchWorker(id int) {
+	l := s.log.With().Int("worker", id).Logger()
+
+	for {
+		item, ok := s.queue.Pop()
+		if !ok {
+			l.Debug().Msg("task queue closed, stopping worker")
+			return
 		}
-	}()
+
+		l.Trace().Msgf("dequeued task %s after %s", item.event.Task.ID, time.Since(item.enqueued))
+
+		result := item.result
+
+		err := s.SendWork(item.ctx, item.event)
+		if err != nil {
+			// only dispatched tasks count against the indexer limit
+			s.limits.Release(item.event.Task)
+		}
+
+		if errors.Is(err, ErrNoReadyNodes) {
+			// a parked task is dispatched again later, with nobody waiting for it
+			item.result = nil
+			err = s.holdTask(item)
+		}
+
+		s.queue.Done(item)
+
+		if result != nil {
+			result <- err
+			continue
+		}
+
+		if errors.Is(err, ErrTaskParked) {
+			continue
+		}
+
+		if err != nil {
+			l.Error().Err(err).Msgf("could not dispatch queued task %s", item.event.Task.ID)
+		}
+	}
 }
 
-func (s *Service) SendWork(ctx context.Context, te task.Event) error {
-	l := logger.GetWithCtx(ctx)
+// admitTask counts a task about to be dispatched against its indexer limit. Tasks over the limit stay queued,
+// and tasks held by the rate window are looked at again once it has passed.
+func (s *Service) admitTask(t task.Task) bool {
+	now := time.Now().UTC()
 
-	l.Debug().Msgf("received task: %+v", te.Task)
+	if err := s.limits.Acquire(t, now); err != nil {
+		s.log.Trace().Err(err).Msgf("holding task %s", t.ID)
 
-	l.Trace().Msg("selecting workers")
+		if at := s.limits.RetryAt(t.Indexer, now); !at.IsZero() {
+			s.queue.NotifyAt(at)
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// syncIndexerUsage stops counting tasks against their indexer limit once no node is downloading them anymore.
+func (s *Service) syncIndexerUsage(ctx context.Context) {
+	// tasks may also be admitted again now that older ones left the rate window
+	defer s.queue.Notify()
 
-	// select workers
-	nodes, err := s.selectWorkers(ctx, te.Task)
+	if !s.limits.Tracking() {
+		return
+	}
+
+	fetched, err := s.refreshStats(ctx, s.GetNodes())
 	if err != nil {
-		l.Error().Err(err).Msg("error selecting nodes")
-		return errors.Wrap(err, "could not select nodes for task")
+		s.log.Warn().Err(err).Msg("could not get stats from every node, keeping indexer usage")
+		return
 	}
 
-	if len(nodes) == 0 {
-		l.Info().Msg("found no nodes to send work to")
-		return errors.New("no ready nodes available to handle the task")
+	downloading := map[string]bool{}
+
+	for _, st := range fetched {
+		for _, cs := range st.ClientStats {
+			for _, hash := range cs.Downloading() {
+				downloading[strings.ToLower(hash)] = true
+			}
+		}
 	}
 
-	l.Debug().Msgf("selected %d nodes", len(nodes))
+	released := s.limits.Sync(time.Now().UTC(), func(hash string) bool {
+		return downloading[hash]
+	})
+
+	if released > 0 {
+		s.log.Debug().Msgf("%d tasks finished downloading, releasing indexer limits", released)
+	}
+}
 
-	// TODO proxy download to only download once
+// refreshStats fetches the stats of the ready nodes concurrently, then sets them on the nodes under schedMu,
+// which is only held for that, so a slow agent doesn't hold up selection for other tasks. It returns the fetched
+// stats by node name, and the first error of a node that couldn't be reached. Selection filters out those nodes.
+func (s *Service) refreshStats(ctx context.Context, nodes []*node.Node) (map[string]*stats.Stats, error) {
+	results := make([]*stats.Stats, len(nodes))
+	errs := make([]error, len(nodes))
 
 	fetcher := errgroup.Group{}
 
-	var succeeded atomic.Int64
-
-	// post to worker nodes
-	for _, n := range nodes {
-		subLogger := l.With().Str("node", n.Name).Logger()
+	for i, n := range nodes {
+		if n.Status != node.StatusReady {
+			continue
+		}
 
 		fetcher.Go(func() error {
-			subLogger.Debug().Msgf("sending task to: %s", n.Name)
+			results[i], errs[i] = n.FetchStats(ctx)
+			return errs[i]
+		})
+	}
 
-			if err := n.StartTask(ctx, &te); err != nil {
-				subLogger.Error().Err(err).Msgf("error could not send task to node: %s", n.Name)
-				return err
-			}
+	err := fetcher.Wait()
 
-			subLogger.Info().Msgf("successfully sent task to %s", n.Name)
+	fetched := map[string]*stats.Stats{}
 
-			succeeded.Add(1)
+	s.schedMu.Lock()
+	for i, n := range nodes {
+		if results[i] == nil && errs[i] == nil {
+			continue
+		}
 
-			return nil
-		})
+		n.SetStats(results[i], errs[i])
+
+		if results[i] != nil {
+			fetched[n.Name] = results[i]
+		}
 	}
+	s.schedMu.Unlock()
 
-	// wait for every node, then apply best-effort semantics: the task is
-	// considered scheduled as long as at least one node accepted it.
-	err = fetcher.Wait()
+	return fetched, err
+}
 
-	ok := succeeded.Load()
-	if ok == 0 {
-		l.Error().Err(err).Msg("error sending task: all nodes failed")
-		return errors.Wrap(err, "failed to send task to any node")
-	}
+// syncCompletedTasks moves replicas to Completed once their node reports the torrent fully downloaded,
+// and running tasks to Completed once none of their replicas is still downloading.
+func (s *Service) syncCompletedTasks(ctx context.Context) {
+	syncer := errgroup.Group{}
 
-	if err != nil {
-		l.Warn().Err(err).Msgf("scheduled download on %d/%d nodes; some nodes failed", ok, len(nodes))
-	} else {
-		l.Info().Msgf("successfully scheduled download on %d nodes", ok)
+	for _, rec := range s.tasks.WithState(task.Running) {
+		syncer.Go(func() error {
+			s.syncCompletedReplicas(ctx, rec)
+			return nil
+		})
 	}
 
-	return nil
+	_ = syncer.Wait()
 }
 
-func (s *Service) selectWorkers(ctx context.Context, t task.Task) ([]*node.Node, error) {
-	// hardcoded scheduler for now
-	var sc scheduler.LeastActive
+func (s *Service) syncCompletedReplicas(ctx context.Context, rec TaskRecord) {
+	id := rec.Task.ID
 
-	// select candidates
-	candidates := sc.SelectCandidateNodes(ctx, t, s.workerNodes)
-	if len(candidates) == 0 {
-		return nil, nil
-	}
+	finished := make([]bool, len(rec.Replicas))
 
-	// score
-	scores := sc.Score(ctx, t, candidates)
-	if len(scores) == 0 {
-		return nil, nil
-	}
+	fetcher := errgroup.Group{}
 
-	// pick
-	nodes := sc.PickN(scores, candidates, t.MaxAllowedReplicas)
+	for i, rep := range rec.Replicas {
+		if rep.State != task.Running {
+			continue
+		}
 
-	s.log.Trace().Msgf("task max replicas %d", t.MaxAllowedReplicas)
+		hash := rep.Hash
+		if hash == "" {
+			hash = rec.Task.InfoHash
+		}
 
-	return nodes, nil
-}
-
-//func (s *Service) SelectWorkers(t task.Task) ([]*node.Node, error) {
-//	var scdl scheduler.Scheduler
-//	switch t.SchedulerType {
-//	case "greedy":
-//		scdl = &scheduler.Greedy{Name: "greedy"}
-//	case "roundrobin":
-//		scdl = &scheduler.RoundRobin{Name: "roundrobin"}
-//	default:
-//		scdl = &scheduler.Epvm{Name: "epvm"}
-//	}
-//
-//	// select candidates
-//	candidates := scdl.SelectCandidateNodes(t, s.workerNodes)
-//	if candidates == nil {
-//		return nil, errors.Errorf("no available candidates match resource request for task: %v", t)
-//	}
-//
-//	// score
-//	scores := scdl.Score(t, candidates)
-//	if scores == nil {
-//		return nil, errors.Errorf("no scores to task: %v", t)
-//	}
-//
-//	// pick
-//	nodes := scdl.Pick(scores, candidates)
-//
-//	return nodes, nil
-//}
+		n := s.getNode(rep.Node)
+		if hash == "" || n == nil || n.Status != node.StatusReady {
+			continue
+		}
 
-func (s *Service) AddTask(ctx context.Context, te task.Event) error {
-	// TODO add to queue
+		fetcher.Go(func() error {
+			torrents, err := n.InspectTask(ctx, hash)
+			if err != nil {
+				s.log.Warn().Err(err).Msgf("could not inspect task %s on node: %s", id, n.Name)
+				return nil
+			}
 
-	return s.SendWork(ctx, te)
-}
+			finished[i] = slices.ContainsFunc(torrents, func(t agent.TorrentStatus) bool {
+				return t.Progress >= 1
+			})
+
+			return nil
+		})
+	}
+
+	_ = fetcher.Wait()
 
-func (s *Service) QueueTask(ctx context.Context, te task.Event) {
-	// TODO add to queue
+	completed, downloading := 0, 0
 
-	s.SendWork(ctx, te)
+	for i, rep := range rec.Replicas {
+		switch {
+		case finished[i]:
+			s.transitionTask(ctx, id, task.Completed, rep.Node, "finished downloading")
+			completed++
+		case rep.State == task.Completed:
+			completed++
+		case rep.State != task.Failed:
+			downloading++
+		}
+	}
+
+	if downloading == 0 && completed > 0 {
+		s.transitionTask(ctx, id, task.Completed, "", fmt.Sprintf("completed on %d/%d nodes", completed, len(rec.Replicas)))
+	}
+}
+
+// GetIndexerUsage returns the usage of every limited indexer, with the number of its tasks waiting in the queue.
+func (s *Service) GetIndexerUsage() []IndexerUsage {
+	usage := s.limits.Usage(time.Now().UTC())
+
+	qs := s.GetQueueStats()
+
+	for i := range usage {
+		for _, ref := range slices.Concat(qs.Items, qs.Parked) {
+			if normalizeIndexer(ref.Indexer) == usage[i].Indexer {
+				usage[i].Queued++
+			}
+		}
+	}
+
+	return usage
+}
+
+// holdTask parks a task that found no ready nodes until its max wait runs out.
+func (s *Service) holdTask(item *queueItem) error {
+	l := logger.GetWithCtx(item.ctx)
+
+	id := item.event.Task.ID
+
+	maxWait := item.event.Task.MaxWait.Duration()
+	if maxWait <= 0 {
+		maxWait = s.cfg.Scheduler.MaxWait
+	}
+
+	if maxWait <= 0 {
+		s.transitionTask(item.ctx, id, task.Failed, "", "no ready nodes available")
+		return ErrNoReadyNodes
+	}
+
+	now := time.Now().UTC()
+
+	if item.deadline.IsZero() {
+		item.deadline = now.Add(maxWait)
+	}
+
+	if now.After(item.deadline) {
+		s.transitionTask(item.ctx, id, task.Failed, "", fmt.Sprintf("no ready nodes within max wait of %s", maxWait))
+		return ErrNoReadyNodes
+	}
+
+	l.Info().Msgf("no ready nodes for task %s, holding until %s", id, item.deadline.Format(time.RFC3339))
+
+	s.parked.Park(item)
+
+	return ErrTaskParked
+}
+
+// releaseParkedTasks fails parked tasks past their deadline and queues the rest again when a node is ready.
+func (s *Service) releaseParkedTasks() {
+	if s.parked.Len() == 0 {
+		return
+	}
+
+	for _, item := range s.parked.Expire(time.Now().UTC()) {
+		s.log.Warn().Msgf("task %s expired waiting for ready nodes", item.event.Task.ID)
+		s.transitionTask(item.ctx, item.event.Task.ID, task.Failed, "", fmt.Sprintf("no ready nodes before deadline %s", item.deadline.Format(time.RFC3339)))
+	}
+
+	if !slices.ContainsFunc(s.GetNodes(), func(n *node.Node) bool { return n.Status == node.StatusReady }) {
+		return
+	}
+
+	for _, item := range s.parked.Drain() {
+		if err := s.queue.Push(item); err != nil {
+			s.log.Warn().Err(err).Msgf("could not queue parked task %s", item.event.Task.ID)
+			s.parked.Park(item)
+			continue
+		}
+
+		s.log.Debug().Msgf("queued parked task %s", item.event.Task.ID)
+	}
+}
+
+func (s *Service) GetQueueStats() QueueStats {
+	qs := s.queue.Stats()
+	qs.Workers = s.cfg.Queue.Workers
+	qs.Parked = s.parked.Refs()
+
+	return qs
+}
+
+// GetQueuePosition returns the 1-based position of a task in the queue, or 0 if it is not waiting.
+func (s *Service) GetQueuePosition(id uuid.UUID) int {
+	return s.queue.Position(id)
+}
+
+func (s *Service) SendWork(ctx context.Context, te task.Event) error {
+	l := logger.GetWithCtx(ctx)
+
+	l.Debug().Msgf("received task: %+v", te.Task)
+
+	s.tasks.Add(te.Task, "task received")
+
+	l.Trace().Msg("selecting workers")
+
+	if s.taskCancelled(te.Task.ID) {
+		return errors.Wrapf(ErrTaskCancelled, "task %s", te.Task.ID)
+	}
+
+	// fetch stats before selecting, nodes that can't be reached are filtered out with the error
+	_, _ = s.refreshStats(ctx, s.GetNodes())
+
+	// select workers and reserve their resources in one step so concurrent dispatches don't overcommit a node
+	s.schedMu.Lock()
+	nodes, spares, err := s.selectWorkers(ctx, te.Task)
+	allocate(te.Task, nodes)
+	s.schedMu.Unlock()
+
+	if err != nil {
+		l.Error().Err(err).Msg("error selecting nodes")
+		s.transitionTask(ctx, te.Task.ID, task.Failed, "", err.Error())
+		return errors.Wrap(err, "could not select nodes for task")
+	}
+
+	if len(nodes) == 0 {
+		l.Info().Msg("found no nodes to send work to")
+		return ErrNoReadyNodes
+	}
+
+	l.Debug().Msgf("selected %d nodes", len(nodes))
+
+	s.transitionTask(ctx, te.Task.ID, task.Scheduled, "", fmt.Sprintf("selected %d nodes", len(nodes)))
+
+	wanted := len(nodes)
+	sent := len(nodes)
+
+	ok, err := s.dispatch(ctx, te, nodes, "selected by scheduler")
+
+	// reschedule failed replicas on the next best candidates
+	for attempt := 1; ok < wanted && attempt <= s.cfg.Scheduler.RescheduleAttempts && len(spares) > 0 && !s.taskCancelled(te.Task.ID); attempt++ {
+		// spares were ranked before the first round, check them again with fresh stats and the allocations made since
+		_, _ = s.refreshStats(ctx, spares)
+
+		s.schedMu.Lock()
+		var retry []*node.Node
+		retry, spares = s.takeSpares(ctx, te.Task, spares, wanted-ok)
+		allocate(te.Task, retry)
+		s.schedMu.Unlock()
+
+		if len(retry) == 0 {
+			l.Info().Msg("no spare nodes left to reschedule failed replicas on")
+			break
+		}
+
+		l.Info().Msgf("rescheduling %d failed replicas, attempt %d/%d", len(retry), attempt, s.cfg.Scheduler.RescheduleAttempts)
+
+		s.transitionTask(ctx, te.Task.ID, task.Scheduled, "", fmt.Sprintf("rescheduling %d failed replicas, attempt %d/%d", len(retry), attempt, s.cfg.Scheduler.RescheduleAttempts))
+
+		retryOk, retryErr := s.dispatch(ctx, te, retry, fmt.Sprintf("rescheduled, attempt %d", attempt))
+
+		ok += retryOk
+		sent += len(retry)
+		if retryErr != nil {
+			err = retryErr
+		}
+	}
+
+	if s.taskCancelled(te.Task.ID) {
+		l.Info().Msgf("task %s was cancelled while dispatching", te.Task.ID)
+		return errors.Wrapf(ErrTaskCancelled, "task %s", te.Task.ID)
+	}
+
+	if ok == 0 {
+		l.Error().Err(err).Msg("error sending task: all nodes failed")
+		s.transitionTask(ctx, te.Task.ID, task.Failed, "", "all nodes failed")
+		return errors.Wrap(err, "failed to send task to any node")
+	}
+
+	s.transitionTask(ctx, te.Task.ID, task.Running, "", fmt.Sprintf("running on %d/%d nodes", ok, wanted))
+
+	if ok < wanted {
+		l.Warn().Err(err).Msgf("scheduled download on %d/%d nodes after trying %d nodes; some nodes failed", ok, wanted, sent)
+	} else if err != nil {
+		l.Warn().Err(err).Msgf("scheduled download on %d/%d nodes after rescheduling failed replicas", ok, wanted)
+	} else {
+		l.Info().Msgf("successfully scheduled download on %d nodes", ok)
+	}
+
+	return nil
+}
+
+// takeSpares returns up to n spares, in rank order, that can still take the task, and the spares left after them.
+// Spares that can't are dropped. With a strict spread a spare can replace a failed replica on its own label value,
+// but not join a value that still has a replica. schedMu must be held.
+func (s *Service) takeSpares(ctx context.Context, t task.Task, spares []*node.Node, n int) ([]*node.Node, []*node.Node) {
+	l := logger.GetWithCtx(ctx)
+
+	var used map[string]bool
+	if t.SpreadBy != "" && t.SpreadStrict {
+		used = s.spreadValues(ctx, t)
+	}
+
+	var picked []*node.Node
+
+	for len(spares) > 0 && len(picked) < n {
+		spare := spares[0]
+		spares = spares[1:]
+
+		if reason := scheduler.CheckNode(ctx, t, spare); reason != "" {
+			l.Debug().Msgf("skipping spare node %s for task %s: %s", spare.Name, t.ID, reason)
+			continue
+		}
+
+		if used != nil {
+			value := scheduler.LabelValue(ctx, spare, t.SpreadBy)
+			if used[value] {
+				l.Debug().Msgf("skipping spare node %s for task %s: %s=%s already has a replica", spare.Name, t.ID, t.SpreadBy, value)
+				continue
+			}
+
+			used[value] = true
+		}
+
+		picked = append(picked, spare)
+	}
+
+	return picked, spares
+}
+
+// spreadValues returns the values of the spread label on the nodes with a replica of the task that didn't fail.
+func (s *Service) spreadValues(ctx context.Context, t task.Task) map[string]bool {
+	used := map[string]bool{}
+
+	rec, ok := s.tasks.Get(t.ID)
+	if !ok {
+		return used
+	}
+
+	for _, rep := range rec.Replicas {
+		if rep.State == task.Failed {
+			continue
+		}
+
+		if n := s.getNode(rep.Node); n != nil {
+			used[scheduler.LabelValue(ctx, n, t.SpreadBy)] = true
+		}
+	}
+
+	return used
+}
+
+// allocate reserves the task resource requests on the nodes. dispatch releases them when the node fails,
+// or holds them until the agent reports the torrent.
+func allocate(t task.Task, nodes []*node.Node) {
+	for _, n := range nodes {
+		n.Allocate(t.Cpu, t.Memory, t.DiskRequest())
+	}
+}
+
+// dispatch sends the task to every node concurrently and returns how many accepted it.
+func (s *Service) dispatch(ctx context.Context, te task.Event, nodes []*node.Node, reason string) (int, error) {
+	l := logger.GetWithCtx(ctx)
+
+	fetcher := errgroup.Group{}
+
+	var succeeded atomic.Int64
+
+	// post to worker nodes
+	for _, n := range nodes {
+		subLogger := l.With().Str("node", n.Name).Logger()
+
+		s.transitionTask(ctx, te.Task.ID, task.Scheduled, n.Name, reason)
+
+		fetcher.Go(func() error {
+			if s.taskCancelled(te.Task.ID) {
+				n.Release(te.Task.Cpu, te.Task.Memory, te.Task.DiskRequest())
+				s.transitionTask(ctx, te.Task.ID, task.Failed, n.Name, "cancelled")
+				return nil
+			}
+
+			subLogger.Debug().Msgf("sending task to: %s", n.Name)
+
+			resp, err := n.StartTask(ctx, &te)
+			if err != nil {
+				n.Release(te.Task.Cpu, te.Task.Memory, te.Task.DiskRequest())
+
+				subLogger.Error().Err(err).Msgf("error could not send task to node: %s", n.Name)
+				s.transitionTask(ctx, te.Task.ID, task.Failed, n.Name, err.Error())
+				return err
+			}
+
+			subLogger.Info().Msgf("successfully sent task to %s", n.Name)
+
+			// CancelTask snapshots the replicas when it marks the task: either it sees the hash and removes
+			// the torrent, or the task is cancelled by now and the torrent is removed here
+			cancelled, deleteFiles := s.tasks.SetReplicaHash(te.Task.ID, n.Name, resp.Hash)
+
+			if cancelled {
+				n.Release(te.Task.Cpu, te.Task.Memory, te.Task.DiskRequest())
+				s.removeCancelled(ctx, te.Task, n, resp.Hash, deleteFiles)
+				return nil
+			}
+
+			// keep the reservation until the agent reports the torrent downloading
+			if resp.Hash != "" {
+				n.Hold(resp.Hash, te.Task.Cpu, te.Task.Memory, te.Task.DiskRequest())
+			} else {
+				n.Release(te.Task.Cpu, te.Task.Memory, te.Task.DiskRequest())
+			}
+
+			s.transitionTask(ctx, te.Task.ID, task.Running, n.Name, "accepted by agent")
+
+			succeeded.Add(1)
+
+			return nil
+		})
+	}
+
+	// wait for every node, then apply best-effort semantics: the task is
+	// considered scheduled as long as at least one node accepted it.
+	err := fetcher.Wait()
+
+	return int(succeeded.Load()), err
+}
+
+// taskCancelled reports if the task was cancelled.
+func (s *Service) taskCancelled(id uuid.UUID) bool {
+	cancelled, _ := s.tasks.Cancelled(id)
+
+	return cancelled
+}
+
+// removeCancelled removes a torrent the node accepted after its task was cancelled.
+func (s *Service) removeCancelled(ctx context.Context, t task.Task, n *node.Node, hash string, deleteFiles bool) {
+	l := logger.GetWithCtx(ctx)
+
+	if hash == "" {
+		hash = t.InfoHash
+	}
+
+	if hash == "" {
+		l.Warn().Msgf("task %s was cancelled while sending it to node %s, but its infohash is unknown", t.ID, n.Name)
+		s.transitionTask(ctx, t.ID, task.Failed, n.Name, "cancelled, torrent left on node: no infohash")
+		return
+	}
+
+	if err := n.StopTask(ctx, hash, deleteFiles); err != nil {
+		l.Error().Err(err).Msgf("could not remove cancelled task %s from node: %s", t.ID, n.Name)
+		s.transitionTask(ctx, t.ID, task.Failed, n.Name, fmt.Sprintf("cancelled, could not remove torrent: %v", err))
+		return
+	}
+
+	l.Info().Msgf("removed cancelled task %s from node %s", t.ID, n.Name)
+
+	s.transitionTask(ctx, t.ID, task.Failed, n.Name, "cancelled")
+}
+
+// transitionTask moves the task, or its replica on nodeName, to dst. Invalid transitions are logged and ignored.
+func (s *Service) transitionTask(ctx context.Context, id uuid.UUID, dst task.State, nodeName string, reason string) {
+	l := logger.GetWithCtx(ctx)
+
+	ev, err := s.tasks.Transition(id, dst, nodeName, reason)
+	if err != nil {
+		if errors.Is(err, ErrTaskCancelled) {
+			l.Debug().Err(err).Msgf("not moving cancelled task %s to %s", id, dst)
+			return
+		}
+
+		l.Error().Err(err).Msgf("could not transition task %s to %s", id, dst)
+		return
+	}
+
+	l.Debug().Str("node", ev.Node).Msgf("task %s: %s -> %s: %s", id, ev.PrevState, ev.State, reason)
+}
+
+// selectWorkers returns the nodes picked for the task, and the remaining candidates ranked by score
+// to reschedule failed replicas on.
+func (s *Service) selectWorkers(ctx context.Context, t task.Task) ([]*node.Node, []*node.Node, error) {
+	name, sc := s.schedulerFor(t)
+
+	decision := SchedulingDecision{
+		TaskID:    t.ID,
+		Scheduler: name,
+		CreatedAt: time.Now().UTC(),
+	}
+	defer s.saveDecision(ctx, &decision)
+
+	if t.ForceAdd {
+		if err := s.tasks.Note(t.ID, forceAddNote); err != nil {
+			s.log.Error().Err(err).Msgf("could not note force add for task %s", t.ID)
+		}
+	}
+
+	// select candidates
+	candidates := sc.SelectCandidateNodes(ctx, t, s.GetNodes())
+	if len(candidates) == 0 {
+		return nil, nil, nil
+	}
+
+	for _, c := range candidates {
+		decision.Candidates = append(decision.Candidates, c.Name)
+	}
+
+	// score
+	scores := sc.Score(ctx, t, candidates)
+	if len(scores) == 0 {
+		return nil, nil, nil
+	}
+
+	decision.Scores = scores
+
+	// pick
+	nodes, spares := scheduler.PickNodes(ctx, sc, t, scores, candidates)
+
+	for _, n := range nodes {
+		decision.Picked = append(decision.Picked, n.Name)
+	}
+
+	if note := spreadNote(t, nodes); note != "" {
+		if err := s.tasks.Note(t.ID, note); err != nil {
+			s.log.Error().Err(err).Msgf("could not note spread for task %s", t.ID)
+		}
+	}
+
+	if note := fallbackNote(t, decision.Picked); note != "" {
+		if err := s.tasks.Note(t.ID, note); err != nil {
+			s.log.Error().Err(err).Msgf("could not note fallback for task %s", t.ID)
+		}
+	}
+
+	s.log.Trace().Msgf("task max replicas %d", t.MaxAllowedReplicas)
+
+	return nodes, spares, nil
+}
+
+// spreadNote describes a strict spread that picked fewer replicas than the task asked for
+func spreadNote(t task.Task, nodes []*node.Node) string {
+	if t.SpreadBy == "" || !t.SpreadStrict || len(nodes) >= max(t.MaxAllowedReplicas, 1) {
+		return ""
+	}
+
+	return fmt.Sprintf("only %d distinct %q values for %d replicas", len(nodes), t.SpreadBy, t.MaxAllowedReplicas)
+}
+
+// fallbackNote describes picked nodes outside the pinned nodes of the task
+func fallbackNote(t task.Task, picked []string) string {
+	if len(t.Nodes) == 0 || !slices.ContainsFunc(picked, func(name string) bool { return !slices.Contains(t.Nodes, name) }) {
+		return ""
+	}
+
+	return fmt.Sprintf("pinned nodes %v not ready, fell back to %v", t.Nodes, picked)
+}
+
+func (s *Service) defaultScheduler() string {
+	return strings.ToLower(strings.TrimSpace(s.cfg.Scheduler.Default))
+}
+
+// schedulerFor returns the scheduler picked by the task scheduler_type, or the configured default.
+func (s *Service) schedulerFor(t task.Task) (string, scheduler.Scheduler) {
+	if t.SchedulerType != "" {
+		name := strings.ToLower(strings.TrimSpace(t.SchedulerType))
+		if sc, ok := s.schedulers[name]; ok {
+			return name, sc
+		}
+
+		s.log.Warn().Msgf("unknown scheduler %q for task %s, using %s", t.SchedulerType, t.ID, s.defaultScheduler())
+	}
+
+	return s.defaultScheduler(), s.schedulers[s.defaultScheduler()]
+}
+
+func (s *Service) saveDecision(ctx context.Context, d *SchedulingDecision) {
+	if s.db == nil {
+		return
+	}
+
+	if err := s.db.SaveDecision(ctx, *d); err != nil {
+		s.log.Error().Err(err).Msgf("could not save scheduling decision for task %s", d.TaskID)
+	}
+}
+
+// GetTask returns the task with its event history and the live torrent state from every node it was sent to.
+func (s *Service) GetTask(ctx context.Context, id uuid.UUID) (*TaskDetail, error) {
+	rec, ok := s.tasks.Get(id)
+	if !ok {
+		return nil, errors.Wrapf(ErrTaskNotFound, "task %s", id)
+	}
+
+	detail := &TaskDetail{
+		TaskRecord: rec,
+		Nodes:      make([]NodeTaskStatus, len(rec.Replicas)),
+		Decisions:  make([]SchedulingDecision, 0),
+	}
+
+	if s.db != nil {
+		decisions, err := s.db.ListDecisions(ctx, id)
+		if err != nil {
+			s.log.Error().Err(err).Msgf("could not load scheduling decisions for task %s", id)
+		} else {
+			detail.Decisions = decisions
+		}
+	}
+
+	fetcher := errgroup.Group{}
+
+	for i, rep := range rec.Replicas {
+		detail.Nodes[i] = NodeTaskStatus{
+			Node:     rep.Node,
+			State:    rep.State,
+			Hash:     rep.Hash,
+			Torrents: make([]agent.TorrentStatus, 0),
+		}
+
+		if rep.Hash == "" || rep.State == task.Failed {
+			continue
+		}
+
+		n := s.getNode(rep.Node)
+		if n == nil {
+			detail.Nodes[i].Error = "node not found"
+			continue
+		}
+
+		fetcher.Go(func() error {
+			torrents, err := n.InspectTask(ctx, rep.Hash)
+			if err != nil {
+				s.log.Error().Err(err).Msgf("could not inspect task %s on node: %s", id, n.Name)
+				detail.Nodes[i].Error = err.Error()
+				return nil
+			}
+
+			detail.Nodes[i].Torrents = torrents
+
+			return nil
+		})
+	}
+
+	_ = fetcher.Wait()
+
+	return detail, nil
+}
+
+// CancelTask removes a queued task, or the torrent from every node that holds it, and marks the task Failed.
+// Dispatches in flight see the task cancelled and remove the torrents they still send.
+func (s *Service) CancelTask(ctx context.Context, id uuid.UUID, deleteFiles bool) (*CancelResult, error) {
+	l := logger.GetWithCtx(ctx)
+
+	rec, err := s.tasks.Cancel(id, deleteFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// a failed replica without a hash never reached its node, a torrent with the same hash there belongs to something else
+	replicas := slices.DeleteFunc(slices.Clone(rec.Replicas), func(rep *Replica) bool {
+		return rep.State == task.Failed && rep.Hash == ""
+	})
+
+	result := &CancelResult{
+		TaskID:   id,
+		Dequeued: s.queue.Remove(id) || s.parked.Remove(id),
+		Nodes:    make([]NodeCancelResult, len(replicas)),
+	}
+
+	remover := errgroup.Group{}
+
+	for i, rep := range replicas {
+		result.Nodes[i] = NodeCancelResult{Node: rep.Node}
+
+		hash := rep.Hash
+		if hash == "" {
+			hash = rec.Task.InfoHash
+		}
+
+		if hash == "" {
+			result.Nodes[i].Error = "no infohash recorded for node"
+			continue
+		}
+
+		n := s.getNode(rep.Node)
+		if n == nil {
+			result.Nodes[i].Error = "node not found"
+			continue
+		}
+
+		remover.Go(func() error {
+			if err := n.StopTask(ctx, hash, deleteFiles); err != nil {
+				l.Error().Err(err).Msgf("could not remove task %s from node: %s", id, n.Name)
+				result.Nodes[i].Error = err.Error()
+				return nil
+			}
+
+			result.Nodes[i].Removed = true
+
+			return nil
+		})
+	}
+
+	_ = remover.Wait()
+
+	for _, nr := range result.Nodes {
+		if nr.Removed {
+			s.failTask(ctx, id, nr.Node, "cancelled")
+		}
+	}
+
+	s.failTask(ctx, id, "", "cancelled")
+
+	l.Info().Msgf("cancelled task %s, delete files: %t", id, deleteFiles)
+
+	return result, nil
+}
+
+// failTask moves the task, or its replica on nodeName, to Failed unless it already finished.
+func (s *Service) failTask(ctx context.Context, id uuid.UUID, nodeName string, reason string) {
+	l := logger.GetWithCtx(ctx)
+
+	ev, ok, err := s.tasks.Fail(id, nodeName, reason)
+	if err != nil {
+		l.Error().Err(err).Msgf("could not fail task %s", id)
+		return
+	}
+
+	if ok {
+		l.Debug().Str("node", ev.Node).Msgf("task %s: %s -> %s: %s", id, ev.PrevState, ev.State, reason)
+	}
+}
+
+func (s *Service) getNode(name string) *node.Node {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	for _, n := range s.workerNodes {
+		if n.Name == name {
+			return n
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) ListTasks(ctx context.Context, filter TaskFilter) (TaskList, error) {
+	return s.tasks.List(filter)
+}
+
+// AddTask enqueues the task and waits until a dispatch worker has sent it to the selected nodes.
+func (s *Service) AddTask(ctx context.Context, te task.Event) error {
+	// a known id would run the dispatch of the existing task again
+	if _, ok := s.tasks.State(te.Task.ID); ok {
+		return errors.Wrapf(ErrTaskExists, "task %s", te.Task.ID)
+	}
+
+	reason := s.routeTask(ctx, &te.Task)
+
+	if err := s.checkIndexerLimit(&te.Task); err != nil {
+		return err
+	}
+
+	if err := s.prepareTask(ctx, &te.Task); err != nil {
+		return err
+	}
+
+	// the hash claim belongs to the task that was added first, so it stays
+	if !s.tasks.Add(te.Task, reason) {
+		return errors.Wrapf(ErrTaskExists, "task %s", te.Task.ID)
+	}
+
+	item := &queueItem{
+		ctx:    ctx,
+		event:  te,
+		result: make(chan error, 1),
+	}
+
+	if err := s.queue.Push(item); err != nil {
+		s.hashes.Release(te.Task.InfoHash, te.Task.ID)
+		s.transitionTask(ctx, te.Task.ID, task.Failed, "", err.Error())
+		return errors.Wrap(err, "could not queue task")
+	}
+
+	select {
+	case err := <-item.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// routeTask applies the first matching routing rule and returns the reason to record the task with.
+func (s *Service) routeTask(ctx context.Context, t *task.Task) string {
+	rule := s.router.Apply(t)
+	if rule == "" {
+		return "task received"
+	}
+
+	l := logger.GetWithCtx(ctx)
+	l.Debug().Msgf("task %s routed by rule %q", t.Name, rule)
+
+	return fmt.Sprintf("task received, routed by rule %q", rule)
+}
+
+// checkIndexerLimit refuses the task if its indexer is over a limit in reject mode.
+// Indexers in queue mode hold the task in the queue instead.
+func (s *Service) checkIndexerLimit(t *task.Task) error {
+	if !s.limits.Rejects(t.Indexer) {
+		return nil
+	}
+
+	return s.limits.Allow(t.Indexer, time.Now().UTC())
+}
+
+// QueueTask enqueues the task without waiting for it to be dispatched.
+func (s *Service) QueueTask(ctx context.Context, te task.Event) error {
+	// a known id would run the dispatch of the existing task again
+	if _, ok := s.tasks.State(te.Task.ID); ok {
+		return errors.Wrapf(ErrTaskExists, "task %s", te.Task.ID)
+	}
+
+	reason := s.routeTask(ctx, &te.Task)
+
+	if err := s.checkIndexerLimit(&te.Task); err != nil {
+		return err
+	}
+
+	if err := s.prepareTask(ctx, &te.Task); err != nil {
+		return err
+	}
+
+	// the hash claim belongs to the task that was added first, so it stays
+	if !s.tasks.Add(te.Task, reason) {
+		return errors.Wrapf(ErrTaskExists, "task %s", te.Task.ID)
+	}
+
+	item := &queueItem{
+		ctx:   ctx,
+		event: te,
+	}
+
+	if err := s.queue.Push(item); err != nil {
+		s.hashes.Release(te.Task.InfoHash, te.Task.ID)
+		s.transitionTask(ctx, te.Task.ID, task.Failed, "", err.Error())
+		return errors.Wrap(err, "could not queue task")
+	}
+
+	return nil
 }
 
 type RegisterRequest struct {
@@ -406,6 +1437,7 @@ type RegisterRequest struct {
 	ClientAddr  string            `json:"client_addr"`
 	AgentToken  string            `json:"token"`
 	Labels      map[string]string `json:"labels"`
+	Taints      []task.Taint      `json:"taints,omitempty"`
 	ServerToken string            `json:"-"`
 }
 
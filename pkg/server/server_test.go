@@ -0,0 +1,620 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/agent"
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/stats"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/autobrr/go-qbittorrent"
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAgent serves the agent api for a single node.
+type fakeAgent struct {
+	name   string
+	stats  stats.Stats
+	labels map[string]string
+
+	// start answers StartTask, returning the infohash or an error status
+	start func(te task.Event) (string, int)
+	// fetchingStats, when set, runs before the stats are served
+	fetchingStats func()
+
+	m        sync.Mutex
+	started  []task.Event
+	stopped  []string
+	progress map[string]float64
+}
+
+func newFakeAgent(name string, freeDisk uint64) *fakeAgent {
+	return &fakeAgent{
+		name: name,
+		stats: stats.Stats{
+			MemStats:  &linux.MemInfo{MemTotal: 8 * 1024 * 1024, MemAvailable: 6 * 1024 * 1024},
+			DiskStats: &linux.Disk{All: 1000 << 30, Free: freeDisk, Used: 1000<<30 - freeDisk},
+			LoadStats: &linux.LoadAvg{},
+			CpuCount:  4,
+			ClientStats: map[string]stats.ClientStats{
+				"qbit": {Name: "qbit", MaxActiveDownloadsAllowed: 5, Ready: true, Status: stats.ClientStatusReady},
+			},
+		},
+		start: func(te task.Event) (string, int) {
+			return "hash-" + te.Task.Name, http.StatusOK
+		},
+	}
+}
+
+func (a *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+
+	switch {
+	case r.Method == http.MethodGet && path == "stats":
+		if a.fetchingStats != nil {
+			a.fetchingStats()
+		}
+
+		a.m.Lock()
+		defer a.m.Unlock()
+
+		_ = json.NewEncoder(w).Encode(a.stats)
+
+	case r.Method == http.MethodGet && path == "labels":
+		labels := a.labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+
+		_ = json.NewEncoder(w).Encode(labels)
+
+	case r.Method == http.MethodPost && path == "tasks":
+		var te task.Event
+		if err := json.NewDecoder(r.Body).Decode(&te); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		a.m.Lock()
+		a.started = append(a.started, te)
+		a.m.Unlock()
+
+		hash, status := a.start(te)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"task_id": te.Task.ID.String(), "hash": hash})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "tasks/"):
+		hash := strings.TrimPrefix(path, "tasks/")
+
+		a.m.Lock()
+		defer a.m.Unlock()
+
+		torrents := make([]agent.TorrentStatus, 0)
+		if progress, ok := a.progress[hash]; ok {
+			torrents = append(torrents, agent.TorrentStatus{Client: "qbit", Hash: hash, Progress: progress})
+		}
+
+		_ = json.NewEncoder(w).Encode(torrents)
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "tasks/"):
+		a.m.Lock()
+		a.stopped = append(a.stopped, strings.TrimPrefix(path, "tasks/"))
+		a.m.Unlock()
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// SetFreeDisk changes the free disk the agent reports
+func (a *fakeAgent) SetFreeDisk(free uint64) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	a.stats.DiskStats = &linux.Disk{All: 1000 << 30, Free: free, Used: 1000<<30 - free}
+}
+
+// SetProgress changes the progress the agent reports for the torrent
+func (a *fakeAgent) SetProgress(hash string, progress float64) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if a.progress == nil {
+		a.progress = map[string]float64{}
+	}
+
+	a.progress[hash] = progress
+}
+
+func (a *fakeAgent) Started() int {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	return len(a.started)
+}
+
+func (a *fakeAgent) Stopped() []string {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	return append([]string(nil), a.stopped...)
+}
+
+// newTestService starts the agents and returns a service with them as ready nodes.
+func newTestService(t *testing.T, cfg *Config, agents ...*fakeAgent) *Service {
+	t.Helper()
+
+	for _, a := range agents {
+		srv := httptest.NewServer(a)
+		t.Cleanup(srv.Close)
+
+		cfg.Nodes = append(cfg.Nodes, &AgentNode{Name: a.name, Addr: srv.URL})
+	}
+
+	s := NewService(cfg, nil)
+
+	for _, n := range s.GetNodes() {
+		n.Status = node.StatusReady
+	}
+
+	return s
+}
+
+func newTestEvent(name string) task.Event {
+	te := task.NewEvent()
+	te.Task = task.NewTask()
+	te.Task.Name = name
+
+	return te
+}
+
+func TestService_CancelTask(t *testing.T) {
+	t.Run("cancel while sending", func(t *testing.T) {
+		agent := newFakeAgent("node0", 500<<30)
+
+		sending, release := make(chan struct{}), make(chan struct{})
+		agent.start = func(te task.Event) (string, int) {
+			close(sending)
+			<-release
+			return "abc", http.StatusOK
+		}
+
+		s := newTestService(t, &Config{}, agent)
+
+		te := newTestEvent("cancelled")
+		s.tasks.Add(te.Task, "task received")
+
+		done := make(chan error, 1)
+		go func() { done <- s.SendWork(t.Context(), te) }()
+
+		<-sending
+
+		result, err := s.CancelTask(t.Context(), te.Task.ID, true)
+		require.NoError(t, err)
+		require.Len(t, result.Nodes, 1)
+
+		close(release)
+		assert.ErrorIs(t, <-done, ErrTaskCancelled)
+
+		// the torrent that reached the node after the cancel is removed by the dispatch
+		assert.Contains(t, agent.Stopped(), "abc")
+
+		rec, ok := s.tasks.Get(te.Task.ID)
+		require.True(t, ok)
+		assert.True(t, rec.Cancelled)
+		assert.Equal(t, task.Failed, rec.Task.State)
+		require.Len(t, rec.Replicas, 1)
+		assert.Equal(t, task.Failed, rec.Replicas[0].State)
+
+		for _, ev := range rec.Events {
+			assert.NotEqual(t, task.Running, ev.State)
+		}
+	})
+
+	t.Run("falls back to the task infohash and cancels again quietly", func(t *testing.T) {
+		agent := newFakeAgent("node0", 500<<30)
+		agent.start = func(te task.Event) (string, int) { return "", http.StatusOK }
+
+		s := newTestService(t, &Config{}, agent)
+
+		te := newTestEvent("no-hash")
+		te.Task.InfoHash = "def"
+
+		require.NoError(t, s.SendWork(t.Context(), te))
+
+		result, err := s.CancelTask(t.Context(), te.Task.ID, false)
+		require.NoError(t, err)
+		require.Len(t, result.Nodes, 1)
+		assert.True(t, result.Nodes[0].Removed)
+		assert.Equal(t, []string{"def"}, agent.Stopped())
+
+		rec, _ := s.tasks.Get(te.Task.ID)
+		events := len(rec.Events)
+
+		_, err = s.CancelTask(t.Context(), te.Task.ID, false)
+		require.NoError(t, err)
+
+		rec, _ = s.tasks.Get(te.Task.ID)
+		assert.Len(t, rec.Events, events)
+		assert.Equal(t, task.Failed, rec.Task.State)
+	})
+
+	t.Run("skips nodes that never accepted the task", func(t *testing.T) {
+		refused, accepted := newFakeAgent("node0", 500<<30), newFakeAgent("node1", 500<<30)
+		refused.start = func(te task.Event) (string, int) { return "", http.StatusInternalServerError }
+		accepted.start = func(te task.Event) (string, int) { return "", http.StatusOK }
+
+		s := newTestService(t, &Config{}, refused, accepted)
+
+		te := newTestEvent("partly-sent")
+		te.Task.InfoHash = "def"
+		te.Task.MaxAllowedReplicas = 2
+
+		require.NoError(t, s.SendWork(t.Context(), te))
+
+		result, err := s.CancelTask(t.Context(), te.Task.ID, true)
+		require.NoError(t, err)
+		require.Len(t, result.Nodes, 1)
+		assert.Equal(t, "node1", result.Nodes[0].Node)
+		assert.True(t, result.Nodes[0].Removed)
+
+		assert.Empty(t, refused.Stopped())
+		assert.Equal(t, []string{"def"}, accepted.Stopped())
+	})
+}
+
+func TestService_SendWork_reschedule(t *testing.T) {
+	fail := func(task.Event) (string, int) { return "", http.StatusInternalServerError }
+
+	tests := []struct {
+		name    string
+		failing []int
+		// full are spares that fill up while the first round is sent
+		full    []int
+		wantErr bool
+		running []string
+		failed  []string
+	}{
+		{
+			name:    "spare takes the failed replica",
+			failing: []int{0},
+			running: []string{"node1", "node2"},
+			failed:  []string{"node0"},
+		},
+		{
+			name:    "full spares are skipped",
+			failing: []int{0},
+			full:    []int{2},
+			running: []string{"node1", "node3"},
+			failed:  []string{"node0"},
+		},
+		{
+			name:    "spares fail too",
+			failing: []int{0, 2},
+			running: []string{"node1", "node3"},
+			failed:  []string{"node0", "node2"},
+		},
+		{
+			name:    "not enough spares",
+			failing: []int{0, 2, 3},
+			running: []string{"node1"},
+			failed:  []string{"node0", "node2", "node3"},
+		},
+		{
+			name:    "every node fails",
+			failing: []int{0, 1, 2, 3},
+			wantErr: true,
+			failed:  []string{"node0", "node1", "node2", "node3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// greedy ranks the nodes by free disk: node0, node1, node2, node3
+			agents := make([]*fakeAgent, 4)
+			for i := range agents {
+				agents[i] = newFakeAgent(fmt.Sprintf("node%d", i), uint64(900-100*i)<<30)
+			}
+
+			for _, i := range tt.failing {
+				agents[i].start = fail
+			}
+
+			if len(tt.full) > 0 {
+				agents[0].start = func(te task.Event) (string, int) {
+					for _, i := range tt.full {
+						agents[i].SetFreeDisk(1 << 30)
+					}
+					return "", http.StatusInternalServerError
+				}
+			}
+
+			s := newTestService(t, &Config{Scheduler: Scheduler{RescheduleAttempts: 3}}, agents...)
+
+			te := newTestEvent("release")
+			te.Task.SchedulerType = "greedy"
+			te.Task.MaxAllowedReplicas = 2
+			te.Task.Disk = 10 << 30
+
+			err := s.SendWork(t.Context(), te)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			rec, ok := s.tasks.Get(te.Task.ID)
+			require.True(t, ok)
+
+			var running, failed []string
+			for _, rep := range rec.Replicas {
+				switch rep.State {
+				case task.Running:
+					running = append(running, rep.Node)
+				case task.Failed:
+					failed = append(failed, rep.Node)
+				}
+			}
+
+			slices.Sort(running)
+			slices.Sort(failed)
+
+			assert.Equal(t, tt.running, running)
+			assert.Equal(t, tt.failed, failed)
+
+			if tt.wantErr {
+				assert.Equal(t, task.Failed, rec.Task.State)
+			} else {
+				assert.Equal(t, task.Running, rec.Task.State)
+				assert.Equal(t, fmt.Sprintf("running on %d/2 nodes", len(tt.running)), rec.Events[len(rec.Events)-1].Reason)
+			}
+
+			for _, i := range tt.full {
+				assert.Zero(t, agents[i].Started())
+			}
+		})
+	}
+}
+
+func TestService_SendWork_strictSpread(t *testing.T) {
+	fail := func(task.Event) (string, int) { return "", http.StatusInternalServerError }
+
+	tests := []struct {
+		name    string
+		failing []int
+		running []string
+	}{
+		{name: "failed replica is replaced on its own value", failing: []int{0}, running: []string{"node1", "node2"}},
+		{name: "values with a live replica are skipped", failing: []int{0, 2}, running: []string{"node1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// greedy ranks the nodes by free disk: node0 and node2 are in eu, node1 and node3 in us
+			agents := make([]*fakeAgent, 4)
+			for i := range agents {
+				agents[i] = newFakeAgent(fmt.Sprintf("node%d", i), uint64(900-100*i)<<30)
+				agents[i].labels = map[string]string{"region": []string{"eu", "us"}[i%2]}
+			}
+
+			for _, i := range tt.failing {
+				agents[i].start = fail
+			}
+
+			s := newTestService(t, &Config{Scheduler: Scheduler{RescheduleAttempts: 3}}, agents...)
+
+			te := newTestEvent("spread")
+			te.Task.SchedulerType = "greedy"
+			te.Task.MaxAllowedReplicas = 2
+			te.Task.SpreadBy = "region"
+			te.Task.SpreadStrict = true
+
+			require.NoError(t, s.SendWork(t.Context(), te))
+
+			rec, ok := s.tasks.Get(te.Task.ID)
+			require.True(t, ok)
+
+			var running []string
+			for _, rep := range rec.Replicas {
+				if rep.State == task.Running {
+					running = append(running, rep.Node)
+				}
+			}
+
+			slices.Sort(running)
+			assert.Equal(t, tt.running, running)
+			assert.Zero(t, agents[3].Started())
+		})
+	}
+}
+
+func TestService_syncIndexerUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		client stats.ClientStats
+		want   int
+	}{
+		{
+			name:   "queued torrent is still downloading",
+			client: stats.ClientStats{Incomplete: []string{"ABC"}},
+			want:   1,
+		},
+		{
+			name:   "older agents report active downloads only",
+			client: stats.ClientStats{ActiveDownloads: []qbittorrent.Torrent{{Hash: "abc", State: qbittorrent.TorrentStateStalledDl}}},
+			want:   1,
+		},
+		{
+			name:   "finished torrent is released",
+			client: stats.ClientStats{Incomplete: []string{"def"}},
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := newFakeAgent("node0", 500<<30)
+			agent.stats.ClientStats["qbit"] = tt.client
+
+			s := newTestService(t, &Config{Indexers: map[string]IndexerLimit{"alpha": {MaxConcurrent: 1}}}, agent)
+
+			tk := task.NewTask()
+			tk.Indexer = "alpha"
+			tk.InfoHash = "abc"
+			s.limits.Restore(tk, time.Now().UTC().Add(-2*indexerSyncGrace))
+
+			s.syncIndexerUsage(t.Context())
+
+			usage := s.GetIndexerUsage()
+			require.Len(t, usage, 1)
+			assert.Equal(t, tt.want, usage[0].Downloading)
+		})
+	}
+}
+
+func TestService_rateWindow(t *testing.T) {
+	const window = 300 * time.Millisecond
+
+	agent := newFakeAgent("node0", 500<<30)
+	s := newTestService(t, &Config{Queue: Queue{Workers: 1}, Indexers: map[string]IndexerLimit{"alpha": {MaxNew: 1, Window: window}}}, agent)
+
+	s.ProcessTasks()
+	t.Cleanup(s.queue.Close)
+
+	push := func(name string) chan error {
+		te := newTestEvent(name)
+		te.Task.Indexer = "alpha"
+		s.tasks.Add(te.Task, "task received")
+
+		item := &queueItem{ctx: t.Context(), event: te, result: make(chan error, 1)}
+		require.NoError(t, s.queue.Push(item))
+
+		return item.result
+	}
+
+	start := time.Now()
+	require.NoError(t, <-push("first"))
+
+	// nothing else happens in the queue, the end of the window has to wake the workers up
+	held := push("second")
+
+	select {
+	case err := <-held:
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), window)
+	case <-time.After(5 * window):
+		t.Fatal("held task was not dispatched after the rate window")
+	}
+
+	assert.Equal(t, 2, agent.Started())
+}
+
+func TestService_AddTask_existingID(t *testing.T) {
+	s := NewService(&Config{}, nil)
+
+	te := newTestEvent("known")
+	s.tasks.Add(te.Task, "task received")
+
+	again := newTestEvent("again")
+	again.Task.ID = te.Task.ID
+
+	assert.ErrorIs(t, s.AddTask(t.Context(), again), ErrTaskExists)
+	assert.ErrorIs(t, s.QueueTask(t.Context(), again), ErrTaskExists)
+	assert.Zero(t, s.queue.Len())
+
+	rec, ok := s.tasks.Get(te.Task.ID)
+	require.True(t, ok)
+	assert.Equal(t, "known", rec.Task.Name)
+	assert.Len(t, rec.Events, 1)
+}
+
+func TestService_syncCompletedTasks(t *testing.T) {
+	agent0 := newFakeAgent("node0", 500<<30)
+	agent1 := newFakeAgent("node1", 500<<30)
+	agent2 := newFakeAgent("node2", 500<<30)
+	agent2.start = func(te task.Event) (string, int) { return "", http.StatusInternalServerError }
+
+	s := newTestService(t, &Config{}, agent0, agent1, agent2)
+
+	te := newTestEvent("finished")
+	te.Task.MaxAllowedReplicas = 3
+
+	require.NoError(t, s.SendWork(t.Context(), te))
+
+	agent0.SetProgress("hash-finished", 1)
+	agent1.SetProgress("hash-finished", 0.5)
+
+	s.syncCompletedTasks(t.Context())
+
+	rec, ok := s.tasks.Get(te.Task.ID)
+	require.True(t, ok)
+	assert.Equal(t, task.Running, rec.Task.State)
+	assert.Equal(t, task.Completed, rec.replica("node0").State)
+	assert.Equal(t, task.Running, rec.replica("node1").State)
+
+	agent1.SetProgress("hash-finished", 1)
+
+	s.syncCompletedTasks(t.Context())
+
+	rec, _ = s.tasks.Get(te.Task.ID)
+	assert.Equal(t, task.Completed, rec.Task.State)
+	assert.Equal(t, task.Completed, rec.replica("node1").State)
+	assert.Equal(t, task.Failed, rec.replica("node2").State)
+
+	list, err := s.ListTasks(t.Context(), TaskFilter{})
+	require.NoError(t, err)
+	require.Len(t, list.Tasks, 1)
+	assert.Equal(t, 2, list.Tasks[0].Replicas.Completed)
+	assert.Equal(t, 1, list.Tasks[0].Replicas.Failed)
+}
+
+func TestService_SendWork_slowAgent(t *testing.T) {
+	slow := newFakeAgent("slow", 500<<30)
+	fast := newFakeAgent("fast", 500<<30)
+
+	fetching, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	slow.fetchingStats = func() {
+		once.Do(func() { close(fetching) })
+		<-release
+	}
+
+	s := newTestService(t, &Config{}, slow, fast)
+
+	te := newTestEvent("dispatched")
+
+	done := make(chan error, 1)
+	go func() { done <- s.SendWork(t.Context(), te) }()
+
+	<-fetching
+
+	// stats are fetched without holding the scheduling lock, so cancels and other dispatches don't wait
+	require.True(t, s.schedMu.TryLock())
+	s.schedMu.Unlock()
+
+	other := newTestEvent("cancelled")
+	s.tasks.Add(other.Task, "task received")
+
+	_, err := s.CancelTask(t.Context(), other.Task.ID, false)
+	require.NoError(t, err)
+
+	close(release)
+	require.NoError(t, <-done)
+
+	assert.Equal(t, 1, slow.Started()+fast.Started())
+}
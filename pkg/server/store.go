@@ -0,0 +1,361 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// SchedulingDecision records how the scheduler placed a task.
+type SchedulingDecision struct {
+	ID         int64              `json:"id"`
+	TaskID     uuid.UUID          `json:"task_id"`
+	Scheduler  string             `json:"scheduler"`
+	Candidates []string           `json:"candidates"`
+	Scores     map[string]float64 `json:"scores"`
+	Picked     []string           `json:"picked"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+func (db *DB) SaveNode(ctx context.Context, n *node.Node) error {
+	labels, err := json.Marshal(n.Labels)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal labels")
+	}
+
+	taints, err := json.Marshal(n.Taints)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal taints")
+	}
+
+	query := `INSERT INTO node (name, addr, token, labels, taints, status, date_created, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET
+			addr = excluded.addr,
+			token = excluded.token,
+			labels = excluded.labels,
+			taints = excluded.taints,
+			status = excluded.status,
+			updated_at = excluded.updated_at`
+
+	if _, err := db.handler.ExecContext(ctx, query, n.Name, n.Addr, n.Token, string(labels), string(taints), string(n.Status), n.DateCreated, time.Now().UTC()); err != nil {
+		return errors.Wrapf(err, "could not save node: %s", n.Name)
+	}
+
+	return nil
+}
+
+func (db *DB) UpdateNodeStatus(ctx context.Context, name string, status node.Status) error {
+	query := `UPDATE node SET status = ?, updated_at = ? WHERE name = ?`
+
+	if _, err := db.handler.ExecContext(ctx, query, string(status), time.Now().UTC(), name); err != nil {
+		return errors.Wrapf(err, "could not update node status: %s", name)
+	}
+
+	return nil
+}
+
+func (db *DB) ListNodes(ctx context.Context) ([]*node.Node, error) {
+	query := `SELECT name, addr, token, labels, taints, status, date_created FROM node ORDER BY date_created`
+
+	rows, err := db.handler.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query nodes")
+	}
+
+	defer rows.Close()
+
+	nodes := make([]*node.Node, 0)
+	for rows.Next() {
+		var (
+			name, addr, token, labels, taints, status string
+			dateCreated                               time.Time
+		)
+
+		if err := rows.Scan(&name, &addr, &token, &labels, &taints, &status, &dateCreated); err != nil {
+			return nil, errors.Wrap(err, "could not scan node")
+		}
+
+		n := node.NewNode(name, addr, token, "worker")
+		n.Status = node.Status(status)
+		n.DateCreated = dateCreated
+
+		if err := json.Unmarshal([]byte(labels), &n.Labels); err != nil {
+			return nil, errors.Wrapf(err, "could not unmarshal labels for node: %s", name)
+		}
+
+		if err := json.Unmarshal([]byte(taints), &n.Taints); err != nil {
+			return nil, errors.Wrapf(err, "could not unmarshal taints for node: %s", name)
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, rows.Err()
+}
+
+func (db *DB) SaveTask(ctx context.Context, t task.Task, created time.Time) error {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal task")
+	}
+
+	query := `INSERT INTO task (id, name, indexer, category, state, payload, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			state = excluded.state,
+			payload = excluded.payload,
+			updated_at = excluded.updated_at`
+
+	if _, err := db.handler.ExecContext(ctx, query, t.ID.String(), t.Name, t.Indexer, t.Category, t.State.String(), string(payload), created, time.Now().UTC()); err != nil {
+		return errors.Wrapf(err, "could not save task: %s", t.ID)
+	}
+
+	return nil
+}
+
+func (db *DB) SaveReplica(ctx context.Context, taskID uuid.UUID, rep Replica) error {
+	query := `INSERT INTO task_replica (task_id, node, state, hash, error, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (task_id, node) DO UPDATE SET
+			state = excluded.state,
+			hash = excluded.hash,
+			error = excluded.error,
+			updated_at = excluded.updated_at`
+
+	if _, err := db.handler.ExecContext(ctx, query, taskID.String(), rep.Node, rep.State.String(), rep.Hash, rep.Error, rep.Updated); err != nil {
+		return errors.Wrapf(err, "could not save replica for task: %s node: %s", taskID, rep.Node)
+	}
+
+	return nil
+}
+
+func (db *DB) SaveEvent(ctx context.Context, ev task.Event) error {
+	query := `INSERT INTO task_event (id, task_id, prev_state, state, node, reason, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	if _, err := db.handler.ExecContext(ctx, query, ev.ID.String(), ev.TaskID.String(), ev.PrevState.String(), ev.State.String(), ev.Node, ev.Reason, ev.Timestamp); err != nil {
+		return errors.Wrapf(err, "could not save event for task: %s", ev.TaskID)
+	}
+
+	return nil
+}
+
+func (db *DB) SaveDecision(ctx context.Context, d SchedulingDecision) error {
+	candidates, err := json.Marshal(d.Candidates)
+	if err != nil {
+		return err
+	}
+
+	scores, err := json.Marshal(d.Scores)
+	if err != nil {
+		return err
+	}
+
+	picked, err := json.Marshal(d.Picked)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO scheduling_decision (task_id, scheduler, candidates, scores, picked, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+
+	if _, err := db.handler.ExecContext(ctx, query, d.TaskID.String(), d.Scheduler, string(candidates), string(scores), string(picked), d.CreatedAt); err != nil {
+		return errors.Wrapf(err, "could not save scheduling decision for task: %s", d.TaskID)
+	}
+
+	return nil
+}
+
+func (db *DB) ListDecisions(ctx context.Context, taskID uuid.UUID) ([]SchedulingDecision, error) {
+	query := `SELECT id, scheduler, candidates, scores, picked, created_at FROM scheduling_decision WHERE task_id = ? ORDER BY id`
+
+	rows, err := db.handler.QueryContext(ctx, query, taskID.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query scheduling decisions")
+	}
+
+	defer rows.Close()
+
+	decisions := make([]SchedulingDecision, 0)
+	for rows.Next() {
+		var (
+			d                          = SchedulingDecision{TaskID: taskID}
+			candidates, scores, picked string
+		)
+
+		if err := rows.Scan(&d.ID, &d.Scheduler, &candidates, &scores, &picked, &d.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan scheduling decision")
+		}
+
+		if err := json.Unmarshal([]byte(candidates), &d.Candidates); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(scores), &d.Scores); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(picked), &d.Picked); err != nil {
+			return nil, err
+		}
+
+		decisions = append(decisions, d)
+	}
+
+	return decisions, rows.Err()
+}
+
+// DeleteTasks deletes the tasks with their replicas, events and scheduling decisions.
+func (db *DB) DeleteTasks(ctx context.Context, ids []uuid.UUID) error {
+	tx, err := db.handler.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM task WHERE id = ?`, id.String()); err != nil {
+			return errors.Wrapf(err, "could not delete task: %s", id)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LoadTasks reads every task with its replicas and events.
+func (db *DB) LoadTasks(ctx context.Context) ([]*TaskRecord, error) {
+	records := map[uuid.UUID]*TaskRecord{}
+	ordered := make([]*TaskRecord, 0)
+
+	rows, err := db.handler.QueryContext(ctx, `SELECT payload, created_at FROM task ORDER BY created_at`)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query tasks")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			payload string
+			created time.Time
+		)
+
+		if err := rows.Scan(&payload, &created); err != nil {
+			return nil, errors.Wrap(err, "could not scan task")
+		}
+
+		rec := &TaskRecord{
+			Replicas: make([]*Replica, 0),
+			Events:   make([]task.Event, 0),
+			Created:  created,
+		}
+
+		if err := json.Unmarshal([]byte(payload), &rec.Task); err != nil {
+			return nil, errors.Wrap(err, "could not unmarshal task")
+		}
+
+		records[rec.Task.ID] = rec
+		ordered = append(ordered, rec)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadReplicas(ctx, records); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadEvents(ctx, records); err != nil {
+		return nil, err
+	}
+
+	return ordered, nil
+}
+
+func (db *DB) loadReplicas(ctx context.Context, records map[uuid.UUID]*TaskRecord) error {
+	rows, err := db.handler.QueryContext(ctx, `SELECT task_id, node, state, hash, error, updated_at FROM task_replica ORDER BY rowid`)
+	if err != nil {
+		return errors.Wrap(err, "could not query replicas")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			taskID, state string
+			rep           Replica
+		)
+
+		if err := rows.Scan(&taskID, &rep.Node, &state, &rep.Hash, &rep.Error, &rep.Updated); err != nil {
+			return errors.Wrap(err, "could not scan replica")
+		}
+
+		rec, err := lookupRecord(records, taskID)
+		if err != nil || rec == nil {
+			continue
+		}
+
+		if rep.State, err = task.ParseState(state); err != nil {
+			return err
+		}
+
+		rec.Replicas = append(rec.Replicas, &rep)
+	}
+
+	return rows.Err()
+}
+
+func (db *DB) loadEvents(ctx context.Context, records map[uuid.UUID]*TaskRecord) error {
+	rows, err := db.handler.QueryContext(ctx, `SELECT id, task_id, prev_state, state, node, reason, timestamp FROM task_event ORDER BY timestamp, rowid`)
+	if err != nil {
+		return errors.Wrap(err, "could not query events")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id, taskID, prevState, state string
+			ev                           task.Event
+		)
+
+		if err := rows.Scan(&id, &taskID, &prevState, &state, &ev.Node, &ev.Reason, &ev.Timestamp); err != nil {
+			return errors.Wrap(err, "could not scan event")
+		}
+
+		rec, err := lookupRecord(records, taskID)
+		if err != nil || rec == nil {
+			continue
+		}
+
+		if ev.ID, err = uuid.Parse(id); err != nil {
+			return err
+		}
+		if ev.PrevState, err = task.ParseState(prevState); err != nil {
+			return err
+		}
+		if ev.State, err = task.ParseState(state); err != nil {
+			return err
+		}
+
+		ev.TaskID = rec.Task.ID
+
+		rec.Events = append(rec.Events, ev)
+	}
+
+	return rows.Err()
+}
+
+func lookupRecord(records map[uuid.UUID]*TaskRecord, taskID string) (*TaskRecord, error) {
+	id, err := uuid.Parse(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return records[id], nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskRegistry_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "distribrr.db")
+
+	db := NewDB(path)
+	require.NoError(t, db.Open())
+
+	tsk := task.NewTask()
+	tsk.Name = "Some.Release.2024.1080p"
+	tsk.Indexer = "mock"
+
+	reg := newTaskRegistry(db)
+	reg.Add(tsk, "task received")
+
+	_, err := reg.Transition(tsk.ID, task.Scheduled, "", "selected 1 nodes")
+	require.NoError(t, err)
+	_, err = reg.Transition(tsk.ID, task.Scheduled, "node0", "selected by scheduler")
+	require.NoError(t, err)
+	reg.SetReplicaHash(tsk.ID, "node0", "abc")
+
+	require.NoError(t, db.Close())
+
+	// reopen and load everything back
+	db = NewDB(path)
+	require.NoError(t, db.Open())
+	defer db.Close()
+
+	loaded := newTaskRegistry(db)
+	require.NoError(t, loaded.Load(t.Context()))
+
+	rec, ok := loaded.Get(tsk.ID)
+	require.True(t, ok)
+
+	// the task was never sent, and the queue is gone after the restart
+	assert.Equal(t, task.Failed, rec.Task.State)
+	assert.Equal(t, "mock", rec.Task.Indexer)
+	assert.Len(t, rec.Events, 5)
+	require.Len(t, rec.Replicas, 1)
+	assert.Equal(t, "node0", rec.Replicas[0].Node)
+	assert.Equal(t, "abc", rec.Replicas[0].Hash)
+	assert.Equal(t, task.Failed, rec.Replicas[0].State)
+	assert.Equal(t, restartedReason, rec.Replicas[0].Error)
+}
+
+func TestTaskRegistry_Load_restarted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "distribrr.db")
+
+	db := NewDB(path)
+	require.NoError(t, db.Open())
+
+	reg := newTaskRegistry(db)
+
+	pending := task.NewTask()
+	reg.Add(pending, "task received")
+
+	// sent to node0, node1 was still waiting when the server stopped
+	partial := task.NewTask()
+	reg.Add(partial, "task received")
+	for _, step := range []struct {
+		state task.State
+		node  string
+	}{
+		{task.Scheduled, ""},
+		{task.Scheduled, "node0"},
+		{task.Scheduled, "node1"},
+		{task.Running, "node0"},
+	} {
+		_, err := reg.Transition(partial.ID, step.state, step.node, "dispatching")
+		require.NoError(t, err)
+	}
+
+	running := task.NewTask()
+	reg.Add(running, "task received")
+	for _, state := range []task.State{task.Scheduled, task.Running} {
+		_, err := reg.Transition(running.ID, state, "", "dispatching")
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, db.Close())
+
+	db = NewDB(path)
+	require.NoError(t, db.Open())
+	defer db.Close()
+
+	loaded := newTaskRegistry(db)
+	require.NoError(t, loaded.Load(t.Context()))
+
+	rec, _ := loaded.Get(pending.ID)
+	assert.Equal(t, task.Failed, rec.Task.State)
+	assert.Equal(t, restartedReason, rec.Events[len(rec.Events)-1].Reason)
+
+	rec, _ = loaded.Get(partial.ID)
+	assert.Equal(t, task.Running, rec.Task.State)
+	assert.Equal(t, task.Running, rec.replica("node0").State)
+	assert.Equal(t, task.Failed, rec.replica("node1").State)
+
+	rec, _ = loaded.Get(running.ID)
+	assert.Equal(t, task.Running, rec.Task.State)
+	assert.Len(t, rec.Events, 3)
+
+	// the recovered states were written back
+	again := newTaskRegistry(db)
+	require.NoError(t, again.Load(t.Context()))
+
+	rec, _ = again.Get(pending.ID)
+	assert.Equal(t, task.Failed, rec.Task.State)
+	assert.Len(t, rec.Events, 2)
+}
+
+func TestTaskRegistry_Prune(t *testing.T) {
+	db := NewDB(filepath.Join(t.TempDir(), "distribrr.db"))
+	require.NoError(t, db.Open())
+	defer db.Close()
+
+	reg := newTaskRegistry(db)
+
+	failed := task.NewTask()
+	reg.Add(failed, "task received")
+	_, err := reg.Transition(failed.ID, task.Failed, "", "no ready nodes available")
+	require.NoError(t, err)
+
+	pending := task.NewTask()
+	reg.Add(pending, "task received")
+
+	// tasks that changed after the cutoff are kept
+	assert.Empty(t, reg.Prune(time.Now().UTC().Add(-time.Hour)))
+
+	pruned := reg.Prune(time.Now().UTC().Add(time.Minute))
+	require.Len(t, pruned, 1)
+	assert.Equal(t, failed.ID, pruned[0].Task.ID)
+
+	_, ok := reg.Get(failed.ID)
+	assert.False(t, ok)
+	_, ok = reg.Get(pending.ID)
+	assert.True(t, ok)
+
+	loaded := newTaskRegistry(db)
+	require.NoError(t, loaded.Load(t.Context()))
+
+	_, ok = loaded.Get(failed.ID)
+	assert.False(t, ok)
+}
@@ -0,0 +1,714 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/agent"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskExists   = errors.New("task already exists")
+)
+
+// restartedReason fails tasks and replicas that were waiting to be sent when the server stopped
+const restartedReason = "server restarted before dispatch"
+
+// Replica is the state of a task on a single node.
+type Replica struct {
+	Node    string     `json:"node"`
+	State   task.State `json:"state"`
+	Hash    string     `json:"hash,omitempty"`
+	Error   string     `json:"error,omitempty"`
+	Updated time.Time  `json:"updated"`
+}
+
+// TaskRecord is everything the server knows about a task.
+type TaskRecord struct {
+	Task     task.Task    `json:"task"`
+	Replicas []*Replica   `json:"replicas"`
+	Events   []task.Event `json:"events"`
+	Created  time.Time    `json:"created"`
+	// Cancelled tasks can only move to Failed, so dispatches in flight stop
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	// deleteFiles is set when the task was cancelled with its files
+	deleteFiles bool
+}
+
+func (r *TaskRecord) replica(nodeName string) *Replica {
+	for _, rep := range r.Replicas {
+		if rep.Node == nodeName {
+			return rep
+		}
+	}
+	return nil
+}
+
+// updated returns when the task last changed
+func (r *TaskRecord) updated() time.Time {
+	if len(r.Events) == 0 {
+		return r.Created
+	}
+
+	return r.Events[len(r.Events)-1].Timestamp
+}
+
+func (r *TaskRecord) clone() TaskRecord {
+	c := TaskRecord{
+		Task:        r.Task,
+		Replicas:    make([]*Replica, 0, len(r.Replicas)),
+		Events:      slices.Clone(r.Events),
+		Created:     r.Created,
+		Cancelled:   r.Cancelled,
+		deleteFiles: r.deleteFiles,
+	}
+
+	for _, rep := range r.Replicas {
+		repCopy := *rep
+		c.Replicas = append(c.Replicas, &repCopy)
+	}
+
+	return c
+}
+
+// taskRegistry tracks tasks and their state history on the server.
+// Every change is written through to the database when one is set.
+type taskRegistry struct {
+	tasks map[uuid.UUID]*TaskRecord
+	m     sync.RWMutex
+
+	db  *DB
+	log zerolog.Logger
+}
+
+func newTaskRegistry(db *DB) *taskRegistry {
+	return &taskRegistry{
+		tasks: map[uuid.UUID]*TaskRecord{},
+		db:    db,
+		log:   log.Logger.With().Str("module", "tasks").Logger(),
+	}
+}
+
+// Load reads all tasks from the database into memory.
+func (r *taskRegistry) Load(ctx context.Context) error {
+	if r.db == nil {
+		return nil
+	}
+
+	records, err := r.db.LoadTasks(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, rec := range records {
+		r.tasks[rec.Task.ID] = rec
+		r.recover(rec)
+	}
+
+	r.log.Debug().Msgf("loaded %d tasks from database", len(records))
+
+	return nil
+}
+
+// recover settles a task the server was still dispatching when it stopped. The queue doesn't survive a restart,
+// so tasks that weren't sent anywhere are failed, and tasks already running on some nodes keep running there.
+// r.m must be held.
+func (r *taskRegistry) recover(rec *TaskRecord) {
+	if rec.Task.State != task.Pending && rec.Task.State != task.Scheduled {
+		return
+	}
+
+	running := 0
+
+	for _, rep := range rec.Replicas {
+		switch rep.State {
+		case task.Running:
+			running++
+		case task.Pending, task.Scheduled:
+			if _, err := r.transition(rec, task.Failed, rep.Node, restartedReason); err != nil {
+				r.log.Error().Err(err).Msgf("could not fail task %s on node %s after restart", rec.Task.ID, rep.Node)
+			}
+		}
+	}
+
+	dst, reason := task.Failed, restartedReason
+	if running > 0 {
+		dst, reason = task.Running, fmt.Sprintf("server restarted during dispatch, running on %d nodes", running)
+	}
+
+	if _, err := r.transition(rec, dst, "", reason); err != nil {
+		r.log.Error().Err(err).Msgf("could not move task %s to %s after restart", rec.Task.ID, dst)
+		return
+	}
+
+	r.log.Info().Msgf("task %s: %s", rec.Task.ID, reason)
+}
+
+// Prune forgets finished tasks that didn't change since before, also in the database, and returns them.
+func (r *taskRegistry) Prune(before time.Time) []TaskRecord {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	pruned := make([]TaskRecord, 0)
+	ids := make([]uuid.UUID, 0)
+
+	for id, rec := range r.tasks {
+		if rec.Task.State != task.Completed && rec.Task.State != task.Failed {
+			continue
+		}
+
+		if !rec.updated().Before(before) {
+			continue
+		}
+
+		pruned = append(pruned, rec.clone())
+		ids = append(ids, id)
+
+		delete(r.tasks, id)
+	}
+
+	if r.db != nil && len(ids) > 0 {
+		if err := r.db.DeleteTasks(context.Background(), ids); err != nil {
+			r.log.Error().Err(err).Msgf("could not delete %d pruned tasks", len(ids))
+		}
+	}
+
+	return pruned
+}
+
+func (r *taskRegistry) persistTask(rec *TaskRecord) {
+	if r.db == nil {
+		return
+	}
+
+	if err := r.db.SaveTask(context.Background(), rec.Task, rec.Created); err != nil {
+		r.log.Error().Err(err).Msgf("could not persist task %s", rec.Task.ID)
+	}
+}
+
+func (r *taskRegistry) persistReplica(id uuid.UUID, rep *Replica) {
+	if r.db == nil {
+		return
+	}
+
+	if err := r.db.SaveReplica(context.Background(), id, *rep); err != nil {
+		r.log.Error().Err(err).Msgf("could not persist replica for task %s", id)
+	}
+}
+
+func (r *taskRegistry) persistEvent(ev task.Event) {
+	if r.db == nil {
+		return
+	}
+
+	if err := r.db.SaveEvent(context.Background(), ev); err != nil {
+		r.log.Error().Err(err).Msgf("could not persist event for task %s", ev.TaskID)
+	}
+}
+
+// Add registers a new pending task. Adding an already known task is a no-op and returns false.
+func (r *taskRegistry) Add(t task.Task, reason string) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.tasks[t.ID]; ok {
+		return false
+	}
+
+	t.State = task.Pending
+
+	// the torrent is only needed for dispatch
+	t.Torrent = nil
+
+	ev := task.NewEvent()
+	ev.TaskID = t.ID
+	ev.Reason = reason
+
+	rec := &TaskRecord{
+		Task:     t,
+		Replicas: make([]*Replica, 0),
+		Events:   []task.Event{ev},
+		Created:  ev.Timestamp,
+	}
+
+	r.tasks[t.ID] = rec
+
+	r.persistTask(rec)
+	r.persistEvent(ev)
+
+	return true
+}
+
+// Transition moves the task, or its replica on nodeName when set, to dst and records the event.
+// Cancelled tasks can only move to Failed.
+func (r *taskRegistry) Transition(id uuid.UUID, dst task.State, nodeName string, reason string) (task.Event, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rec, ok := r.tasks[id]
+	if !ok {
+		return task.Event{}, errors.Wrapf(ErrTaskNotFound, "task %s", id)
+	}
+
+	return r.transition(rec, dst, nodeName, reason)
+}
+
+// Fail moves the task, or its replica on nodeName when set, to Failed unless it already finished.
+// ok is false when it was already Failed or Completed.
+func (r *taskRegistry) Fail(id uuid.UUID, nodeName string, reason string) (ev task.Event, ok bool, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rec, found := r.tasks[id]
+	if !found {
+		return task.Event{}, false, errors.Wrapf(ErrTaskNotFound, "task %s", id)
+	}
+
+	state := rec.Task.State
+	if nodeName != "" {
+		state = task.Pending
+		if rep := rec.replica(nodeName); rep != nil {
+			state = rep.State
+		}
+	}
+
+	if state == task.Failed || state == task.Completed {
+		return task.Event{}, false, nil
+	}
+
+	ev, err = r.transition(rec, task.Failed, nodeName, reason)
+	if err != nil {
+		return task.Event{}, false, err
+	}
+
+	return ev, true, nil
+}
+
+// Cancel marks the task cancelled and returns a copy of it. Cancelling again keeps deleting files if asked once.
+func (r *taskRegistry) Cancel(id uuid.UUID, deleteFiles bool) (TaskRecord, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rec, ok := r.tasks[id]
+	if !ok {
+		return TaskRecord{}, errors.Wrapf(ErrTaskNotFound, "task %s", id)
+	}
+
+	rec.Cancelled = true
+	rec.deleteFiles = rec.deleteFiles || deleteFiles
+
+	return rec.clone(), nil
+}
+
+// Cancelled reports if the task was cancelled, and if its files should be deleted.
+func (r *taskRegistry) Cancelled(id uuid.UUID) (cancelled bool, deleteFiles bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	rec, ok := r.tasks[id]
+	if !ok {
+		return false, false
+	}
+
+	return rec.Cancelled, rec.deleteFiles
+}
+
+// transition moves the task or its replica to dst. r.m must be held.
+func (r *taskRegistry) transition(rec *TaskRecord, dst task.State, nodeName string, reason string) (task.Event, error) {
+	id := rec.Task.ID
+
+	if rec.Cancelled && dst != task.Failed {
+		return task.Event{}, errors.Wrapf(ErrTaskCancelled, "task %s: %s", id, dst)
+	}
+
+	if nodeName == "" {
+		ev, err := rec.Task.Transition(dst, "", reason)
+		if err != nil {
+			return task.Event{}, err
+		}
+
+		rec.Events = append(rec.Events, ev)
+
+		r.persistTask(rec)
+		r.persistEvent(ev)
+
+		return ev, nil
+	}
+
+	rep := rec.replica(nodeName)
+	if rep == nil {
+		rep = &Replica{Node: nodeName, State: task.Pending}
+		rec.Replicas = append(rec.Replicas, rep)
+	}
+
+	if !task.ValidStateTransition(rep.State, dst) {
+		return task.Event{}, errors.Wrapf(task.ErrInvalidStateTransition, "task %s on node %s: %s -> %s", id, nodeName, rep.State, dst)
+	}
+
+	ev := task.NewEvent()
+	ev.TaskID = id
+	ev.PrevState = rep.State
+	ev.State = dst
+	ev.Node = nodeName
+	ev.Reason = reason
+
+	rep.State = dst
+	rep.Updated = ev.Timestamp
+	rep.Error = ""
+	if dst == task.Failed {
+		rep.Error = reason
+	}
+
+	rec.Events = append(rec.Events, ev)
+
+	r.persistReplica(id, rep)
+	r.persistEvent(ev)
+
+	return ev, nil
+}
+
+// Note records an event on the task without changing its state.
+func (r *taskRegistry) Note(id uuid.UUID, reason string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rec, ok := r.tasks[id]
+	if !ok {
+		return errors.Wrapf(ErrTaskNotFound, "task %s", id)
+	}
+
+	ev := task.NewEvent()
+	ev.TaskID = id
+	ev.PrevState = rec.Task.State
+	ev.State = rec.Task.State
+	ev.Reason = reason
+
+	rec.Events = append(rec.Events, ev)
+
+	r.persistEvent(ev)
+
+	return nil
+}
+
+// State returns the current state of the task.
+func (r *taskRegistry) State(id uuid.UUID) (task.State, bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	rec, ok := r.tasks[id]
+	if !ok {
+		return task.Pending, false
+	}
+
+	return rec.Task.State, true
+}
+
+// Recent returns copies of the tasks created after since.
+func (r *taskRegistry) Recent(since time.Time) []TaskRecord {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	records := make([]TaskRecord, 0)
+	for _, rec := range r.tasks {
+		if rec.Created.After(since) {
+			records = append(records, rec.clone())
+		}
+	}
+
+	return records
+}
+
+// WithState returns copies of the tasks in one of the states.
+func (r *taskRegistry) WithState(states ...task.State) []TaskRecord {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	records := make([]TaskRecord, 0)
+	for _, rec := range r.tasks {
+		if task.Contains(states, rec.Task.State) {
+			records = append(records, rec.clone())
+		}
+	}
+
+	return records
+}
+
+// SetReplicaHash records the infohash the agent on nodeName reported for the task, unless the task was cancelled.
+// Cancel snapshots the replicas under the same lock, so the hash of a cancelled task is left to the caller
+// to remove, which cancelled and deleteFiles report.
+func (r *taskRegistry) SetReplicaHash(id uuid.UUID, nodeName string, hash string) (cancelled bool, deleteFiles bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rec, ok := r.tasks[id]
+	if !ok {
+		return false, false
+	}
+
+	if rec.Cancelled {
+		return true, rec.deleteFiles
+	}
+
+	if rep := rec.replica(nodeName); rep != nil && hash != "" {
+		rep.Hash = hash
+		r.persistReplica(id, rep)
+	}
+
+	return false, false
+}
+
+// Get returns a copy of the task record.
+func (r *taskRegistry) Get(id uuid.UUID) (TaskRecord, bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	rec, ok := r.tasks[id]
+	if !ok {
+		return TaskRecord{}, false
+	}
+
+	return rec.clone(), true
+}
+
+// TaskDetail is a task record together with the live state of its torrents on each node.
+type TaskDetail struct {
+	TaskRecord
+	Nodes     []NodeTaskStatus     `json:"nodes"`
+	Decisions []SchedulingDecision `json:"decisions"`
+}
+
+type NodeTaskStatus struct {
+	Node     string                `json:"node"`
+	State    task.State            `json:"state"`
+	Hash     string                `json:"hash,omitempty"`
+	Torrents []agent.TorrentStatus `json:"torrents"`
+	Error    string                `json:"error,omitempty"`
+}
+
+type CancelResult struct {
+	TaskID   uuid.UUID          `json:"task_id"`
+	Dequeued bool               `json:"dequeued"`
+	Nodes    []NodeCancelResult `json:"nodes"`
+}
+
+type NodeCancelResult struct {
+	Node    string `json:"node"`
+	Removed bool   `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// TaskSummary is the listing view of a task.
+type TaskSummary struct {
+	ID       uuid.UUID  `json:"id"`
+	Name     string     `json:"name"`
+	State    task.State `json:"state"`
+	Indexer  string     `json:"indexer"`
+	Category string     `json:"category"`
+	Nodes    []string   `json:"nodes"`
+	Created  time.Time  `json:"created"`
+	Replicas Outcome    `json:"replicas"`
+}
+
+// Outcome counts replicas per state.
+type Outcome struct {
+	Wanted    int `json:"wanted"`
+	Scheduled int `json:"scheduled"`
+	Running   int `json:"running"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+func (r *TaskRecord) summary() TaskSummary {
+	ts := TaskSummary{
+		ID:       r.Task.ID,
+		Name:     r.Task.Name,
+		State:    r.Task.State,
+		Indexer:  r.Task.Indexer,
+		Category: r.Task.Category,
+		Nodes:    make([]string, 0, len(r.Replicas)),
+		Created:  r.Created,
+		Replicas: Outcome{Wanted: max(r.Task.MaxAllowedReplicas, 1)},
+	}
+
+	for _, rep := range r.Replicas {
+		ts.Nodes = append(ts.Nodes, rep.Node)
+
+		switch rep.State {
+		case task.Scheduled:
+			ts.Replicas.Scheduled++
+		case task.Running:
+			ts.Replicas.Running++
+		case task.Completed:
+			ts.Replicas.Completed++
+		case task.Failed:
+			ts.Replicas.Failed++
+		}
+	}
+
+	return ts
+}
+
+const (
+	defaultTaskListLimit = 50
+	maxTaskListLimit     = 500
+)
+
+type TaskFilter struct {
+	States  []task.State
+	Indexer string
+	Node    string
+	Name    string
+	Since   time.Time
+	Until   time.Time
+	Cursor  string
+	Limit   int
+}
+
+func (f TaskFilter) match(r *TaskRecord) bool {
+	if len(f.States) > 0 && !task.Contains(f.States, r.Task.State) {
+		return false
+	}
+
+	if f.Indexer != "" && !strings.EqualFold(f.Indexer, r.Task.Indexer) {
+		return false
+	}
+
+	if f.Node != "" && r.replica(f.Node) == nil {
+		return false
+	}
+
+	if f.Name != "" && !strings.Contains(strings.ToLower(r.Task.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+
+	if !f.Since.IsZero() && r.Created.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && !r.Created.Before(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+type TaskList struct {
+	Tasks      []TaskSummary `json:"tasks"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// taskCursor points at the last task of a page. Tasks are listed newest first.
+type taskCursor struct {
+	Created time.Time
+	ID      uuid.UUID
+}
+
+func (c taskCursor) encode() string {
+	raw := fmt.Sprintf("%d:%s", c.Created.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeTaskCursor(cursor string) (taskCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return taskCursor{}, errors.Wrap(err, "invalid cursor")
+	}
+
+	ts, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return taskCursor{}, errors.New("invalid cursor")
+	}
+
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return taskCursor{}, errors.Wrap(err, "invalid cursor")
+	}
+
+	taskID, err := uuid.Parse(id)
+	if err != nil {
+		return taskCursor{}, errors.Wrap(err, "invalid cursor")
+	}
+
+	return taskCursor{Created: time.Unix(0, nanos).UTC(), ID: taskID}, nil
+}
+
+// after reports whether the record sorts after the cursor in newest first order.
+func (c taskCursor) after(r *TaskRecord) bool {
+	if !r.Created.Equal(c.Created) {
+		return r.Created.Before(c.Created)
+	}
+	return r.Task.ID.String() > c.ID.String()
+}
+
+func compareRecords(a, b *TaskRecord) int {
+	if c := b.Created.Compare(a.Created); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Task.ID.String(), b.Task.ID.String())
+}
+
+// List returns a page of tasks matching the filter, newest first.
+func (r *taskRegistry) List(f TaskFilter) (TaskList, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultTaskListLimit
+	}
+	if limit > maxTaskListLimit {
+		limit = maxTaskListLimit
+	}
+
+	var cursor *taskCursor
+	if f.Cursor != "" {
+		c, err := decodeTaskCursor(f.Cursor)
+		if err != nil {
+			return TaskList{}, err
+		}
+		cursor = &c
+	}
+
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	matched := make([]*TaskRecord, 0)
+	for _, rec := range r.tasks {
+		if cursor != nil && !cursor.after(rec) {
+			continue
+		}
+		if !f.match(rec) {
+			continue
+		}
+		matched = append(matched, rec)
+	}
+
+	slices.SortFunc(matched, compareRecords)
+
+	list := TaskList{Tasks: make([]TaskSummary, 0, min(limit, len(matched)))}
+
+	for _, rec := range matched {
+		if len(list.Tasks) == limit {
+			last := list.Tasks[len(list.Tasks)-1]
+			list.NextCursor = taskCursor{Created: last.Created, ID: last.ID}.encode()
+			break
+		}
+		list.Tasks = append(list.Tasks, rec.summary())
+	}
+
+	return list, nil
+}
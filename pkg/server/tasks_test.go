@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addTestRecord puts a record straight into the registry, so tests control its creation time and id
+func addTestRecord(reg *taskRegistry, id string, name string, indexer string, created time.Time, state task.State, nodes ...string) {
+	t := task.NewTask()
+	t.ID = uuid.MustParse(id)
+	t.Name = name
+	t.Indexer = indexer
+	t.State = state
+
+	rec := &TaskRecord{Task: t, Replicas: make([]*Replica, 0), Created: created}
+	for _, n := range nodes {
+		rec.Replicas = append(rec.Replicas, &Replica{Node: n, State: state})
+	}
+
+	reg.tasks[t.ID] = rec
+}
+
+func summaryNames(list TaskList) []string {
+	names := make([]string, 0, len(list.Tasks))
+	for _, ts := range list.Tasks {
+		names = append(names, ts.Name)
+	}
+
+	return names
+}
+
+func TestTaskRegistry_List(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	reg := newTaskRegistry(nil)
+	addTestRecord(reg, "00000000-0000-0000-0000-000000000001", "Old.Show.S01E01", "alpha", base, task.Completed, "node0")
+	addTestRecord(reg, "00000000-0000-0000-0000-000000000002", "Some.Movie.2024", "Beta", base.Add(time.Hour), task.Running, "node0", "node1")
+	// same creation time, ordered by id
+	addTestRecord(reg, "00000000-0000-0000-0000-000000000004", "Tie.B", "alpha", base.Add(2*time.Hour), task.Failed)
+	addTestRecord(reg, "00000000-0000-0000-0000-000000000003", "Tie.A", "alpha", base.Add(2*time.Hour), task.Pending)
+	addTestRecord(reg, "00000000-0000-0000-0000-000000000005", "New.Show.S01E02", "beta", base.Add(3*time.Hour), task.Scheduled, "node1")
+
+	tests := []struct {
+		name   string
+		filter TaskFilter
+		want   []string
+	}{
+		{
+			name: "newest first, equal times by id",
+			want: []string{"New.Show.S01E02", "Tie.A", "Tie.B", "Some.Movie.2024", "Old.Show.S01E01"},
+		},
+		{
+			name:   "states",
+			filter: TaskFilter{States: []task.State{task.Running, task.Completed}},
+			want:   []string{"Some.Movie.2024", "Old.Show.S01E01"},
+		},
+		{
+			name:   "indexer is case-insensitive",
+			filter: TaskFilter{Indexer: "BETA"},
+			want:   []string{"New.Show.S01E02", "Some.Movie.2024"},
+		},
+		{
+			name:   "node",
+			filter: TaskFilter{Node: "node0"},
+			want:   []string{"Some.Movie.2024", "Old.Show.S01E01"},
+		},
+		{
+			name:   "name contains, case-insensitive",
+			filter: TaskFilter{Name: "show"},
+			want:   []string{"New.Show.S01E02", "Old.Show.S01E01"},
+		},
+		{
+			name:   "since is inclusive",
+			filter: TaskFilter{Since: base.Add(2 * time.Hour)},
+			want:   []string{"New.Show.S01E02", "Tie.A", "Tie.B"},
+		},
+		{
+			name:   "until is exclusive",
+			filter: TaskFilter{Until: base.Add(2 * time.Hour)},
+			want:   []string{"Some.Movie.2024", "Old.Show.S01E01"},
+		},
+		{
+			name:   "filters combine",
+			filter: TaskFilter{Indexer: "alpha", Since: base.Add(time.Minute), States: []task.State{task.Failed}},
+			want:   []string{"Tie.B"},
+		},
+		{
+			name:   "no match",
+			filter: TaskFilter{Node: "missing"},
+			want:   []string{},
+		},
+		{
+			name:   "cursor in the middle of equal times",
+			filter: TaskFilter{Cursor: taskCursor{Created: base.Add(2 * time.Hour), ID: uuid.MustParse("00000000-0000-0000-0000-000000000003")}.encode()},
+			want:   []string{"Tie.B", "Some.Movie.2024", "Old.Show.S01E01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := reg.List(tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, summaryNames(list))
+			assert.Empty(t, list.NextCursor)
+		})
+	}
+
+	t.Run("continue from cursor", func(t *testing.T) {
+		var pages [][]string
+
+		filter := TaskFilter{Limit: 2}
+		for {
+			list, err := reg.List(filter)
+			require.NoError(t, err)
+
+			pages = append(pages, summaryNames(list))
+
+			if list.NextCursor == "" {
+				break
+			}
+			filter.Cursor = list.NextCursor
+		}
+
+		assert.Equal(t, [][]string{
+			{"New.Show.S01E02", "Tie.A"},
+			{"Tie.B", "Some.Movie.2024"},
+			{"Old.Show.S01E01"},
+		}, pages)
+	})
+
+	t.Run("cursor keeps filters", func(t *testing.T) {
+		first, err := reg.List(TaskFilter{Indexer: "alpha", Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Tie.A"}, summaryNames(first))
+
+		next, err := reg.List(TaskFilter{Indexer: "alpha", Limit: 1, Cursor: first.NextCursor})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Tie.B"}, summaryNames(next))
+	})
+
+	t.Run("bad cursors", func(t *testing.T) {
+		encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+		for _, cursor := range []string{
+			"not base64!",
+			encode("no-separator"),
+			encode("soon:00000000-0000-0000-0000-000000000001"),
+			encode("1704110400000000000:not-a-uuid"),
+		} {
+			_, err := reg.List(TaskFilter{Cursor: cursor})
+			assert.Error(t, err, cursor)
+		}
+	})
+}
+
+func TestTaskRegistry_List_limit(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	reg := newTaskRegistry(nil)
+	for i := range maxTaskListLimit + 10 {
+		addTestRecord(reg, fmt.Sprintf("00000000-0000-0000-0000-%012d", i), fmt.Sprintf("task-%d", i), "alpha", base.Add(time.Duration(i)*time.Second), task.Pending)
+	}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  int
+	}{
+		{name: "default", limit: 0, want: defaultTaskListLimit},
+		{name: "negative is default", limit: -1, want: defaultTaskListLimit},
+		{name: "explicit", limit: 7, want: 7},
+		{name: "capped", limit: maxTaskListLimit + 5, want: maxTaskListLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := reg.List(TaskFilter{Limit: tt.limit})
+			require.NoError(t, err)
+			assert.Len(t, list.Tasks, tt.want)
+			assert.NotEmpty(t, list.NextCursor)
+		})
+	}
+}
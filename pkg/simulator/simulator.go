@@ -0,0 +1,530 @@
+package simulator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/autobrr/distribrr/pkg/node"
+	"github.com/autobrr/distribrr/pkg/scheduler"
+	"github.com/autobrr/distribrr/pkg/stats"
+	"github.com/autobrr/distribrr/pkg/task"
+
+	"github.com/autobrr/go-qbittorrent"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// MemStats are reported in kB
+const kb = 1024
+
+// Snapshot is the recorded state of a node. It applies from Time until the next snapshot of the same node.
+// A node doesn't exist before its first snapshot.
+type Snapshot struct {
+	Time   time.Time         `json:"time"`
+	Node   string            `json:"node"`
+	Labels map[string]string `json:"labels"`
+	Taints []task.Taint      `json:"taints,omitempty"`
+	Stats  stats.Stats       `json:"stats"`
+}
+
+// TraceTask is a task received at Time. Duration is how long its downloads take once placed,
+// and defaults to Config.DownloadTime.
+type TraceTask struct {
+	Time     time.Time     `json:"time"`
+	Duration task.Duration `json:"duration,omitempty"`
+	Task     task.Task     `json:"task"`
+}
+
+type Config struct {
+	// DownloadTime is how long a placed task keeps downloading when the trace has no duration for it
+	DownloadTime time.Duration
+	// Interval between load samples. 0 disables them
+	Interval time.Duration
+}
+
+// Report is the outcome of replaying a trace through a scheduler.
+type Report struct {
+	Scheduler       string         `json:"scheduler"`
+	Tasks           int            `json:"tasks"`
+	Placed          int            `json:"placed"`
+	Replicas        int            `json:"replicas"`
+	UnderReplicated int            `json:"under_replicated"`
+	Placements      map[string]int `json:"placements"`
+	Rejected        []Rejection    `json:"rejected"`
+	Load            []LoadSample   `json:"load"`
+}
+
+// Rejection is a task that found no candidate nodes.
+type Rejection struct {
+	Time   time.Time `json:"time"`
+	Task   string    `json:"task"`
+	Reason string    `json:"reason"`
+}
+
+// LoadSample is the load of every known node at a point in time.
+type LoadSample struct {
+	Time  time.Time           `json:"time"`
+	Nodes map[string]NodeLoad `json:"nodes"`
+}
+
+type NodeLoad struct {
+	// ActiveDownloads is the recorded active downloads plus the simulated ones, across all clients
+	ActiveDownloads int `json:"active_downloads"`
+	// Simulated is the number of replicas placed by the simulation still downloading
+	Simulated int `json:"simulated"`
+	// DiskUsed is the fraction of disk in use, including simulated downloads
+	DiskUsed float64 `json:"disk_used"`
+}
+
+// placement is a replica placed by the simulation
+type placement struct {
+	task     task.Task
+	node     string
+	start    time.Time
+	duration time.Duration
+}
+
+// ReadSnapshots reads JSON lines of node snapshots, sorted by time.
+func ReadSnapshots(r io.Reader) ([]Snapshot, error) {
+	snapshots, err := readLines[Snapshot](r)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read snapshots")
+	}
+
+	for i, s := range snapshots {
+		if s.Node == "" {
+			return nil, errors.Errorf("snapshot %d has no node name", i+1)
+		}
+	}
+
+	slices.SortStableFunc(snapshots, func(a, b Snapshot) int { return a.Time.Compare(b.Time) })
+
+	return snapshots, nil
+}
+
+// ReadTrace reads JSON lines of tasks, sorted by time. Tasks without an ID or name get one.
+func ReadTrace(r io.Reader) ([]TraceTask, error) {
+	trace, err := readLines[TraceTask](r)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read task trace")
+	}
+
+	for i := range trace {
+		t := &trace[i].Task
+
+		if t.ID == uuid.Nil {
+			t.ID = uuid.New()
+		}
+
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("task-%d", i+1)
+		}
+
+		priority, err := task.ParsePriority(string(t.Priority))
+		if err != nil {
+			return nil, errors.Wrapf(err, "task %s", t.Name)
+		}
+		t.Priority = priority
+	}
+
+	slices.SortStableFunc(trace, func(a, b TraceTask) int { return a.Time.Compare(b.Time) })
+
+	return trace, nil
+}
+
+func readLines[T any](r io.Reader) ([]T, error) {
+	var items []T
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var item T
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+
+		items = append(items, item)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Run replays the trace through a new instance of the named scheduler. Downloads placed by the simulation are added
+// on top of the recorded snapshots, so the scheduler sees the load it caused itself.
+func Run(ctx context.Context, name string, cfg Config, snapshots []Snapshot, trace []TraceTask) (*Report, error) {
+	sc, err := scheduler.New(name)
+	if err != nil {
+		return nil, err
+	}
+
+	sim := &simulation{
+		cfg:       cfg,
+		scheduler: sc,
+		snapshots: snapshots,
+		report: &Report{
+			Scheduler:  name,
+			Tasks:      len(trace),
+			Placements: map[string]int{},
+			Rejected:   make([]Rejection, 0),
+			Load:       make([]LoadSample, 0),
+		},
+	}
+
+	if len(trace) == 0 {
+		return sim.report, nil
+	}
+
+	next := trace[0].Time
+
+	for _, tt := range trace {
+		next = sim.sampleUntil(next, tt.Time)
+		sim.place(ctx, tt)
+	}
+
+	// keep sampling until the simulated downloads are done
+	end := trace[len(trace)-1].Time
+	for _, p := range sim.active {
+		end = later(end, p.start.Add(p.duration))
+	}
+	sim.sampleUntil(next, end)
+
+	return sim.report, nil
+}
+
+type simulation struct {
+	cfg       Config
+	scheduler scheduler.Scheduler
+	snapshots []Snapshot
+	active    []placement
+	// seeding are finished downloads. They keep their disk until the end of the run
+	seeding []placement
+	report  *Report
+}
+
+// sampleUntil records load samples from next up to until, and returns the time of the next sample
+func (s *simulation) sampleUntil(next time.Time, until time.Time) time.Time {
+	if s.cfg.Interval <= 0 {
+		return next
+	}
+
+	for !next.After(until) {
+		nodes := s.nodesAt(next)
+
+		sample := LoadSample{Time: next, Nodes: map[string]NodeLoad{}}
+		for _, n := range nodes {
+			sample.Nodes[n.Name] = s.nodeLoad(n)
+		}
+		s.report.Load = append(s.report.Load, sample)
+
+		next = next.Add(s.cfg.Interval)
+	}
+
+	return next
+}
+
+func (s *simulation) place(ctx context.Context, tt TraceTask) {
+	t := tt.Task
+	nodes := s.nodesAt(tt.Time)
+
+	candidates := s.scheduler.SelectCandidateNodes(ctx, t, nodes)
+	if len(candidates) == 0 {
+		s.reject(ctx, tt, nodes)
+		return
+	}
+
+	nodeScores := s.scheduler.Score(ctx, t, candidates)
+	if len(nodeScores) == 0 {
+		s.report.Rejected = append(s.report.Rejected, Rejection{Time: tt.Time, Task: t.Name, Reason: "no scores"})
+		return
+	}
+
+	picked, _ := scheduler.PickNodes(ctx, s.scheduler, t, nodeScores, candidates)
+	if len(picked) == 0 {
+		s.report.Rejected = append(s.report.Rejected, Rejection{Time: tt.Time, Task: t.Name, Reason: "no nodes picked"})
+		return
+	}
+
+	duration := tt.Duration.Duration()
+	if duration <= 0 {
+		duration = s.cfg.DownloadTime
+	}
+
+	for _, n := range picked {
+		s.active = append(s.active, placement{task: t, node: n.Name, start: tt.Time, duration: duration})
+		s.report.Placements[n.Name]++
+	}
+
+	s.report.Placed++
+	s.report.Replicas += len(picked)
+
+	if len(picked) < max(t.MaxAllowedReplicas, 1) {
+		s.report.UnderReplicated++
+	}
+}
+
+// reject records why no node could take the task
+func (s *simulation) reject(ctx context.Context, tt TraceTask, nodes []*node.Node) {
+	reason := "no nodes"
+
+	if len(nodes) > 0 {
+		_, reasons := scheduler.Filter(ctx, tt.Task, nodes)
+
+		parts := make([]string, 0, len(reasons))
+		for _, name := range slices.Sorted(maps.Keys(reasons)) {
+			parts = append(parts, name+": "+reasons[name])
+		}
+
+		reason = "no candidate nodes"
+		if len(parts) > 0 {
+			reason += ": " + strings.Join(parts, "; ")
+		}
+	}
+
+	s.report.Rejected = append(s.report.Rejected, Rejection{Time: tt.Time, Task: tt.Task.Name, Reason: reason})
+}
+
+// nodesAt returns the nodes known at the time, with the downloads placed by the simulation still running on top
+// of their latest snapshot. Finished downloads only keep using disk, since they are seeded.
+func (s *simulation) nodesAt(at time.Time) []*node.Node {
+	s.active = slices.DeleteFunc(s.active, func(p placement) bool {
+		if at.Before(p.start.Add(p.duration)) {
+			return false
+		}
+
+		s.seeding = append(s.seeding, p)
+		return true
+	})
+
+	latest := map[string]Snapshot{}
+	for _, snap := range s.snapshots {
+		if snap.Time.After(at) {
+			break
+		}
+		latest[snap.Node] = snap
+	}
+
+	nodes := make([]*node.Node, 0, len(latest))
+
+	for _, name := range slices.Sorted(maps.Keys(latest)) {
+		snap := latest[name]
+
+		labels := snap.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+
+		n := node.NewOfflineNode(name, labels, cloneStats(snap.Stats))
+		n.Taints = snap.Taints
+
+		for _, p := range s.active {
+			if p.node == name {
+				addDownload(&n.Stats, p, at)
+			}
+		}
+
+		for _, p := range s.seeding {
+			if disk := uint64(max(p.task.DiskRequest(), 0)); p.node == name && disk > 0 {
+				addDisk(&n.Stats, disk)
+			}
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+func (s *simulation) nodeLoad(n *node.Node) NodeLoad {
+	load := NodeLoad{}
+
+	for _, cs := range n.Stats.ClientStats {
+		load.ActiveDownloads += cs.ActiveDownloadsCount
+	}
+
+	for _, p := range s.active {
+		if p.node == n.Name {
+			load.Simulated++
+		}
+	}
+
+	load.DiskUsed = diskUsed(n.Stats)
+
+	return load
+}
+
+// addDownload adds a simulated download to the node stats, the way the agent would report it:
+// an active download on every client, and its resource requests taken from memory, storage and load.
+// Stats are rebuilt from the snapshot every time, so finished downloads give their resources back, except for disk.
+func addDownload(st *stats.Stats, p placement, at time.Time) {
+	elapsed := at.Sub(p.start)
+	progress := min(float64(elapsed)/float64(p.duration), 1)
+
+	torrent := qbittorrent.Torrent{
+		Hash:     p.task.InfoHash,
+		Name:     p.task.Name,
+		Progress: progress,
+		ETA:      int64((p.duration - elapsed).Seconds()),
+	}
+
+	for name, cs := range st.ClientStats {
+		cs.ActiveDownloadsCount++
+		cs.ActiveDownloads = append(cs.ActiveDownloads, torrent)
+		cs.Ready = cs.ActiveDownloadsCount < cs.MaxActiveDownloadsAllowed
+
+		cs.Status = stats.ClientStatusReady
+		if !cs.Ready {
+			cs.Status = stats.ClientStatusNotReady
+		}
+
+		st.ClientStats[name] = cs
+	}
+
+	if ms := st.MemStats; ms != nil && p.task.Memory > 0 {
+		ms.MemAvailable -= min(ms.MemAvailable, uint64(p.task.Memory)/kb)
+	}
+
+	if disk := uint64(max(p.task.DiskRequest(), 0)); disk > 0 {
+		addDisk(st, disk)
+	}
+
+	if ls := st.LoadStats; ls != nil {
+		ls.Last1Min += p.task.Cpu
+	}
+}
+
+// addDisk takes the download from the storage path each client would put it on, the one with the most room
+// under its storage rules. Paths of the same name share their disk across clients. Nodes without storage paths
+// take it from the root filesystem.
+func addDisk(st *stats.Stats, disk uint64) {
+	paths := map[string]bool{}
+
+	for _, cs := range st.ClientStats {
+		best, found := "", false
+		var bestRoom int64
+
+		for _, path := range cs.Storage {
+			// unreadable paths are reported without a size
+			if path.Total == 0 {
+				continue
+			}
+
+			if room := storageRoom(path); !found || room > bestRoom {
+				best, bestRoom, found = path.Path, room, true
+			}
+		}
+
+		if found {
+			paths[best] = true
+		}
+	}
+
+	if len(paths) == 0 {
+		if ds := st.DiskStats; ds != nil {
+			ds.Free -= min(ds.Free, disk)
+			ds.Used += disk
+		}
+		return
+	}
+
+	for _, cs := range st.ClientStats {
+		for i := range cs.Storage {
+			path := &cs.Storage[i]
+			if !paths[path.Path] || path.Total == 0 {
+				continue
+			}
+
+			path.Free -= min(path.Free, disk)
+			path.Used += disk
+		}
+	}
+}
+
+// storageRoom returns the bytes left on the path before hitting its minFree or maxUsage
+func storageRoom(path stats.StorageStats) int64 {
+	room := int64(path.Free) - int64(path.MinFree)
+
+	if path.MaxUsage > 0 {
+		room = min(room, int64(path.MaxUsage)-int64(path.Used))
+	}
+
+	return room
+}
+
+// diskUsed returns the fraction of disk in use on the storage path with the most free space,
+// or the root filesystem without storage paths, like the schedulers see it
+func diskUsed(st stats.Stats) float64 {
+	var total, used, free uint64
+
+	for _, cs := range st.ClientStats {
+		for _, path := range cs.Storage {
+			if path.Total > 0 && (total == 0 || path.Free > free) {
+				total, used, free = path.Total, path.Used, path.Free
+			}
+		}
+	}
+
+	if total == 0 && st.DiskStats != nil {
+		total, used = st.DiskStats.All, st.DiskStats.Used
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(used) / float64(total)
+}
+
+// cloneStats copies the stats the simulation changes, so snapshots stay as recorded
+func cloneStats(st stats.Stats) stats.Stats {
+	if st.MemStats != nil {
+		ms := *st.MemStats
+		st.MemStats = &ms
+	}
+
+	if st.DiskStats != nil {
+		ds := *st.DiskStats
+		st.DiskStats = &ds
+	}
+
+	if st.LoadStats != nil {
+		ls := *st.LoadStats
+		st.LoadStats = &ls
+	}
+
+	clients := make(map[string]stats.ClientStats, len(st.ClientStats))
+	for name, cs := range st.ClientStats {
+		cs.ActiveDownloads = slices.Clone(cs.ActiveDownloads)
+		cs.Storage = slices.Clone(cs.Storage)
+		clients[name] = cs
+	}
+	st.ClientStats = clients
+
+	return st
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
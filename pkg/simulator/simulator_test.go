@@ -0,0 +1,116 @@
+package simulator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSnapshots = `
+{"time":"2024-01-01T12:00:00Z","node":"node-a","labels":{"region":"eu"},"stats":{"disk_stats":{"all":1000,"used":100,"free":900},"client_stats":{"qbit":{"max_active_downloads_allowed":2,"ready":true,"status":"READY"}}}}
+{"time":"2024-01-01T12:00:00Z","node":"node-b","labels":{"region":"us"},"stats":{"disk_stats":{"all":1000,"used":100,"free":900},"client_stats":{"qbit":{"max_active_downloads_allowed":1,"ready":true,"status":"READY"}}}}
+# node-c joins later
+{"time":"2024-01-01T13:00:00Z","node":"node-c","labels":{"region":"eu"},"stats":{"client_stats":{"qbit":{"max_active_downloads_allowed":5,"ready":true,"status":"READY"}}}}
+`
+
+const testTrace = `
+{"time":"2024-01-01T12:03:00Z","task":{"name":"c","size":100}}
+{"time":"2024-01-01T12:01:00Z","task":{"name":"a","size":100}}
+{"time":"2024-01-01T12:02:00Z","duration":"10m","task":{"name":"b","size":100}}
+{"time":"2024-01-01T12:04:00Z","task":{"name":"d","labels":{"region":"us"}}}
+`
+
+func TestReadTrace(t *testing.T) {
+	trace, err := ReadTrace(strings.NewReader(testTrace))
+	assert.NoError(t, err)
+	assert.Len(t, trace, 4)
+
+	assert.Equal(t, "a", trace[0].Task.Name)
+	assert.Equal(t, 10*time.Minute, trace[1].Duration.Duration())
+	assert.NotEqual(t, trace[0].Task.ID, trace[1].Task.ID)
+
+	_, err = ReadTrace(strings.NewReader(`{"task":{"priority":"urgent"}}`))
+	assert.Error(t, err)
+
+	_, err = ReadSnapshots(strings.NewReader(`{"stats":{}}`))
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	snapshots, err := ReadSnapshots(strings.NewReader(testSnapshots))
+	assert.NoError(t, err)
+
+	trace, err := ReadTrace(strings.NewReader(testTrace))
+	assert.NoError(t, err)
+
+	cfg := Config{DownloadTime: time.Hour, Interval: 30 * time.Minute}
+
+	report, err := Run(context.Background(), "roundrobin", cfg, snapshots, trace)
+	assert.NoError(t, err)
+
+	// node-a and node-b alternate until their slots are full
+	assert.Equal(t, 4, report.Tasks)
+	assert.Equal(t, 3, report.Placed)
+	assert.Equal(t, map[string]int{"node-a": 2, "node-b": 1}, report.Placements)
+
+	// only node-b is in us, and it is full
+	assert.Len(t, report.Rejected, 1)
+	assert.Equal(t, "d", report.Rejected[0].Task)
+	assert.Contains(t, report.Rejected[0].Reason, "node-a: label region=us doesn't match region=eu")
+	assert.Contains(t, report.Rejected[0].Reason, "node-b: client qbit is not ready")
+
+	assert.Equal(t, time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC), report.Load[0].Time)
+
+	// b finished after 10 minutes, node-c joined at 13:00
+	second := report.Load[1]
+	assert.Equal(t, NodeLoad{ActiveDownloads: 2, Simulated: 2, DiskUsed: 0.3}, second.Nodes["node-a"])
+	assert.Equal(t, 0, second.Nodes["node-b"].Simulated)
+	assert.NotContains(t, second.Nodes, "node-c")
+	assert.Contains(t, report.Load[len(report.Load)-1].Nodes, "node-c")
+
+	// recorded snapshots are left as they were
+	assert.Equal(t, 0, snapshots[0].Stats.ClientStats["qbit"].ActiveDownloadsCount)
+
+	_, err = Run(context.Background(), "unknown", cfg, snapshots, trace)
+	assert.Error(t, err)
+}
+
+func TestRun_storage(t *testing.T) {
+	// small root filesystem, downloads go to /data which keeps 100 bytes free
+	snapshots, err := ReadSnapshots(strings.NewReader(`
+{"time":"2024-01-01T12:00:00Z","node":"node-a","stats":{"disk_stats":{"all":100,"used":90,"free":10},"client_stats":{"qbit":{"max_active_downloads_allowed":10,"ready":true,"status":"READY","storage":[{"path":"/data","min_free":100,"total":1000,"used":500,"free":500}]}}}}
+`))
+	assert.NoError(t, err)
+
+	trace, err := ReadTrace(strings.NewReader(`
+{"time":"2024-01-01T12:00:00Z","task":{"name":"a","size":200}}
+{"time":"2024-01-01T12:01:00Z","task":{"name":"b","size":200}}
+{"time":"2024-01-01T12:02:00Z","task":{"name":"c","size":200}}
+{"time":"2024-01-01T12:03:00Z","task":{"name":"e","size":50}}
+{"time":"2024-01-01T13:00:00Z","task":{"name":"d","size":200}}
+`))
+	assert.NoError(t, err)
+
+	report, err := Run(context.Background(), "leastactive", Config{DownloadTime: 30 * time.Minute, Interval: time.Minute}, snapshots, trace)
+	assert.NoError(t, err)
+
+	// a and b fill /data, e fits the disk but not its min free, d doesn't fit either since a and b are seeded
+	assert.Equal(t, 2, report.Placed)
+	assert.Len(t, report.Rejected, 3)
+	assert.Equal(t, "c", report.Rejected[0].Task)
+	assert.Contains(t, report.Rejected[0].Reason, "not enough disk: 200 B requested, 100 B free")
+	assert.Equal(t, "e", report.Rejected[1].Task)
+	assert.Contains(t, report.Rejected[1].Reason, "within the agent storage rules")
+	assert.Equal(t, "d", report.Rejected[2].Task)
+	assert.Contains(t, report.Rejected[2].Reason, "not enough disk: 200 B requested, 100 B free")
+
+	assert.InDelta(t, 0.9, report.Load[2].Nodes["node-a"].DiskUsed, 0.0001)
+
+	// finished downloads keep their disk
+	last := report.Load[len(report.Load)-1].Nodes["node-a"]
+	assert.Zero(t, last.Simulated)
+	assert.InDelta(t, 0.9, last.DiskUsed, 0.0001)
+}
@@ -1,6 +1,9 @@
 package stats
 
 import (
+	"runtime"
+	"strings"
+
 	"github.com/autobrr/go-qbittorrent"
 	"github.com/c9s/goprocinfo/linux"
 	"github.com/rs/zerolog/log"
@@ -55,26 +58,88 @@ type ClientStats struct {
 	Name                      string                `json:"name"`
 	ActiveDownloadsCount      int                   `json:"active_downloads_count"`
 	ActiveDownloads           []qbittorrent.Torrent `json:"active_downloads"`
+	Incomplete                []string              `json:"incomplete,omitempty"` // Incomplete is the hashes of every torrent that isn't done downloading
 	MaxActiveDownloadsAllowed int                   `json:"max_active_downloads_allowed"`
 	Ready                     bool                  `json:"ready"` // Ready is true if ActiveDownloadsCount is less than configured
 	Status                    ClientStatus          `json:"status"`
+	Storage                   []StorageStats        `json:"storage,omitempty"`
+}
+
+// StorageStats is a client storage path with its disk usage and rule limits, all in bytes.
+// A zero MinFree or MaxUsage means no limit.
+type StorageStats struct {
+	Path     string `json:"path"`
+	Tier     int    `json:"tier"`
+	MinFree  uint64 `json:"min_free"`
+	MaxUsage uint64 `json:"max_usage"`
+	Total    uint64 `json:"total"`
+	Used     uint64 `json:"used"`
+	Free     uint64 `json:"free"`
 }
 
 func (c *ClientStats) HasAvailableSlot() bool {
 	return c.ActiveDownloadsCount < c.MaxActiveDownloadsAllowed
 }
 
+// HasDownload reports if the torrent is among the active downloads of the client.
+func (c *ClientStats) HasDownload(hash string) bool {
+	for _, t := range c.ActiveDownloads {
+		if strings.EqualFold(t.Hash, hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Downloading returns the hashes of the torrents the client hasn't finished downloading.
+// Agents that don't report incomplete torrents fall back to the active downloads.
+func (c *ClientStats) Downloading() []string {
+	if c.Incomplete != nil {
+		return c.Incomplete
+	}
+
+	hashes := make([]string, 0, len(c.ActiveDownloads))
+	for _, t := range c.ActiveDownloads {
+		hashes = append(hashes, t.Hash)
+	}
+
+	return hashes
+}
+
+// RemainingBytes returns the bytes the active downloads of the client still need on disk.
+func (c *ClientStats) RemainingBytes() int64 {
+	var remaining int64
+	for _, t := range c.ActiveDownloads {
+		remaining += int64((1 - t.Progress) * float64(t.Size))
+	}
+
+	return remaining
+}
+
 type Stats struct {
 	MemStats      *linux.MemInfo         `json:"mem_stats"`
 	DiskStats     *linux.Disk            `json:"disk_stats"`
 	DiskPathStats map[string]*linux.Disk `json:"disk_path_stats"`
 	CpuStats      *linux.CPUStat         `json:"cpu_stats"`
 	LoadStats     *linux.LoadAvg         `json:"load_stats"`
+	CpuCount      int                    `json:"cpu_count"`
 	TaskCount     int                    `json:"task_count"`
 	ClientStats   map[string]ClientStats `json:"client_stats"`
 	// NetworkStats
 }
 
+// HasDownload reports if any client has the torrent among its active downloads.
+func (s *Stats) HasDownload(hash string) bool {
+	for _, cs := range s.ClientStats {
+		if cs.HasDownload(hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (s *Stats) MemUsedKb() uint64 {
 	return s.MemStats.MemTotal - s.MemStats.MemAvailable
 }
@@ -130,6 +195,7 @@ func GetStats() *Stats {
 		DiskPathStats: map[string]*linux.Disk{},
 		CpuStats:      GetCpuStats(),
 		LoadStats:     GetLoadAvg(),
+		CpuCount:      runtime.NumCPU(),
 		ClientStats:   map[string]ClientStats{},
 	}
 }
@@ -0,0 +1,50 @@
+package task
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Duration is a time.Duration that reads and writes JSON as a string like "90s".
+// Plain numbers are read as seconds.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value * float64(time.Second)))
+	case string:
+		if value == "" {
+			*d = 0
+			return nil
+		}
+
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid duration: %q", value)
+		}
+
+		*d = Duration(parsed)
+	case nil:
+		*d = 0
+	default:
+		return errors.Errorf("invalid duration: %s", b)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package task
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Priority decides dequeue order and who gets scarce node slots.
+type Priority string
+
+const (
+	PriorityCritical Priority = "critical"
+	PriorityNormal   Priority = "normal"
+	PriorityBackfill Priority = "backfill"
+)
+
+var priorityRanks = map[Priority]int{
+	PriorityCritical: 2,
+	PriorityNormal:   1,
+	PriorityBackfill: 0,
+}
+
+// Rank orders priorities, higher goes first. Unset is normal.
+func (p Priority) Rank() int {
+	if rank, ok := priorityRanks[p]; ok {
+		return rank
+	}
+	return priorityRanks[PriorityNormal]
+}
+
+// OrDefault returns normal for an unset priority.
+func (p Priority) OrDefault() Priority {
+	if p == "" {
+		return PriorityNormal
+	}
+	return p
+}
+
+func ParsePriority(name string) (Priority, error) {
+	p := Priority(strings.ToLower(strings.TrimSpace(name)))
+	if p == "" {
+		return PriorityNormal, nil
+	}
+
+	if _, ok := priorityRanks[p]; !ok {
+		return PriorityNormal, errors.Errorf("unknown task priority: %q", name)
+	}
+
+	return p, nil
+}
@@ -1,6 +1,11 @@
 package task
 
-import "log"
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
 
 type State int
 
@@ -12,18 +17,51 @@ const (
 	Failed
 )
 
-func (s State) String() []string {
-	return []string{"Pending", "Scheduled", "Running", "Completed", "Failed"}
+var stateNames = []string{"Pending", "Scheduled", "Running", "Completed", "Failed"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return "Unknown"
+	}
+	return stateNames[s]
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	state, err := ParseState(string(text))
+	if err != nil {
+		return err
+	}
+
+	*s = state
+
+	return nil
+}
+
+// ParseState returns the State matching name, case-insensitive.
+func ParseState(name string) (State, error) {
+	for i, n := range stateNames {
+		if strings.EqualFold(n, name) {
+			return State(i), nil
+		}
+	}
+
+	return Pending, errors.Errorf("unknown task state: %q", name)
 }
 
 var stateTransitionMap = map[State][]State{
-	Pending:   {Scheduled},
+	Pending:   {Scheduled, Failed},
 	Scheduled: {Scheduled, Running, Failed},
 	Running:   {Running, Completed, Failed, Scheduled},
 	Completed: {},
 	Failed:    {Scheduled},
 }
 
+var ErrInvalidStateTransition = errors.New("invalid state transition")
+
 func Contains(states []State, state State) bool {
 	for _, s := range states {
 		if s == state {
@@ -34,6 +72,6 @@ func Contains(states []State, state State) bool {
 }
 
 func ValidStateTransition(src State, dst State) bool {
-	log.Printf("attempting to transition from %#v to %#v\n", src, dst)
+	log.Trace().Msgf("attempting to transition from %s to %s", src, dst)
 	return Contains(stateTransitionMap[src], dst)
 }
@@ -0,0 +1,102 @@
+package task
+
+import (
+	"github.com/pkg/errors"
+)
+
+type TaintEffect string
+
+const (
+	// TaintEffectNoSchedule keeps tasks without a matching toleration off the node
+	TaintEffectNoSchedule TaintEffect = "NoSchedule"
+	// TaintEffectPreferNoSchedule only places tasks without a matching toleration on the node when nothing else fits
+	TaintEffectPreferNoSchedule TaintEffect = "PreferNoSchedule"
+)
+
+// Taint marks a node so it only receives tasks that tolerate it, like a slow archive box.
+type Taint struct {
+	Key    string      `json:"key"`
+	Value  string      `json:"value,omitempty"`
+	Effect TaintEffect `json:"effect"`
+}
+
+func (t Taint) Validate() error {
+	if t.Key == "" {
+		return errors.New("taint key can't be empty")
+	}
+
+	switch t.Effect {
+	case TaintEffectNoSchedule, TaintEffectPreferNoSchedule:
+		return nil
+	}
+
+	return errors.Errorf("taint %s: unknown effect %q", t.Key, t.Effect)
+}
+
+func (t Taint) String() string {
+	if t.Value == "" {
+		return t.Key + ":" + string(t.Effect)
+	}
+
+	return t.Key + "=" + t.Value + ":" + string(t.Effect)
+}
+
+type TolerationOperator string
+
+const (
+	TolerationOpEqual  TolerationOperator = "Equal"
+	TolerationOpExists TolerationOperator = "Exists"
+)
+
+// Toleration lets a task be placed on nodes with a matching taint.
+// An empty operator means Equal, and an empty effect matches every effect.
+type Toleration struct {
+	Key      string             `json:"key"`
+	Operator TolerationOperator `json:"operator,omitempty"`
+	Value    string             `json:"value,omitempty"`
+	Effect   TaintEffect        `json:"effect,omitempty"`
+}
+
+func (t Toleration) Validate() error {
+	switch t.Operator {
+	case "", TolerationOpEqual, TolerationOpExists:
+	default:
+		return errors.Errorf("toleration %s: unknown operator %q", t.Key, t.Operator)
+	}
+
+	switch t.Effect {
+	case "", TaintEffectNoSchedule, TaintEffectPreferNoSchedule:
+	default:
+		return errors.Errorf("toleration %s: unknown effect %q", t.Key, t.Effect)
+	}
+
+	return nil
+}
+
+// Tolerates checks if the toleration matches the taint.
+func (t Toleration) Tolerates(taint Taint) bool {
+	if t.Key != taint.Key {
+		return false
+	}
+
+	if t.Effect != "" && t.Effect != taint.Effect {
+		return false
+	}
+
+	if t.Operator == TolerationOpExists {
+		return true
+	}
+
+	return t.Value == taint.Value
+}
+
+// Tolerates checks if any of the task tolerations matches the taint.
+func (t *Task) Tolerates(taint Taint) bool {
+	for _, toleration := range t.Tolerations {
+		if toleration.Tolerates(taint) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 )
 
 type Task struct {
@@ -13,18 +14,72 @@ type Task struct {
 	Category           string            `json:"category"`
 	Tags               string            `json:"tags"`
 	Indexer            string            `json:"indexer"`
-	State              State             `json:"-"`
-	Cpu                float64           `json:"cpu"`
-	Memory             int64             `json:"memory"`
-	Disk               int64             `json:"disk"`
+	State              State             `json:"state"`
+	Cpu                float64           `json:"cpu"`    // cores
+	Memory             int64             `json:"memory"` // bytes
+	Disk               int64             `json:"disk"`   // bytes, defaults to the torrent size
 	SchedulerType      string            `json:"scheduler_type"`
 	MaxAllowedReplicas int               `json:"max_replicas"`
+	SpreadBy           string            `json:"spread_by,omitempty"`     // put replicas on distinct values of this node label
+	SpreadStrict       bool              `json:"spread_strict,omitempty"` // under-replicate rather than co-locate
 	Labels             map[string]string `json:"labels"`
+	Tolerations        []Toleration      `json:"tolerations,omitempty"`
+	PreferredLabels    []PreferredLabel  `json:"preferred_labels,omitempty"`
+	Selectors          []string          `json:"selectors,omitempty"` // label expressions like "region in (eu, us)" or "network gt 1G"
 	Nodes              []string          `json:"nodes"`
+	NodesFallback      bool              `json:"nodes_fallback,omitempty"` // use any node when the pinned ones aren't ready
 	ForceAdd           bool              `json:"force_add"`
+	Priority           Priority          `json:"priority,omitempty"`
+	MaxWait            Duration          `json:"max_wait,omitempty"`
+	InfoHash           string            `json:"info_hash,omitempty"`
+	Size               uint64            `json:"size,omitempty"`
+	Torrent            []byte            `json:"torrent,omitempty"`
 
-	StartTime  time.Time `json:"-"`
-	FinishTime time.Time `json:"-"`
+	StartTime  time.Time `json:"start_time,omitzero"`
+	FinishTime time.Time `json:"finish_time,omitzero"`
+}
+
+// PreferredLabel adds Weight to the score of nodes with the label. An empty Value matches any value.
+type PreferredLabel struct {
+	Key    string  `json:"key"`
+	Value  string  `json:"value,omitempty"`
+	Weight float64 `json:"weight"`
+}
+
+// DiskRequest returns the disk requested by the task, or the torrent size when it has none.
+func (t *Task) DiskRequest() int64 {
+	if t.Disk > 0 {
+		return t.Disk
+	}
+
+	return int64(t.Size)
+}
+
+// Transition moves the task to dst if the state machine allows it, and returns the event describing the change.
+func (t *Task) Transition(dst State, node string, reason string) (Event, error) {
+	if !ValidStateTransition(t.State, dst) {
+		return Event{}, errors.Wrapf(ErrInvalidStateTransition, "task %s: %s -> %s", t.ID, t.State, dst)
+	}
+
+	ev := NewEvent()
+	ev.TaskID = t.ID
+	ev.PrevState = t.State
+	ev.State = dst
+	ev.Node = node
+	ev.Reason = reason
+
+	t.State = dst
+
+	switch dst {
+	case Running:
+		if t.StartTime.IsZero() {
+			t.StartTime = ev.Timestamp
+		}
+	case Completed, Failed:
+		t.FinishTime = ev.Timestamp
+	}
+
+	return ev, nil
 }
 
 func NewTask() Task {
@@ -47,9 +102,13 @@ func NewTask() Task {
 
 type Event struct {
 	ID        uuid.UUID `json:"id"`
+	TaskID    uuid.UUID `json:"task_id"`
+	PrevState State     `json:"prev_state"`
 	State     State     `json:"state"`
+	Node      string    `json:"node,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
-	Task      Task      `json:"task"`
+	Task      Task      `json:"task,omitzero"`
 }
 
 func NewEvent() Event {